@@ -0,0 +1,213 @@
+package logger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tokens of Parameters.ArchivePattern
+const (
+	ArchiveNameToken  = "{name}"
+	ArchiveDateToken  = "{date}"
+	ArchiveIndexToken = "{index}"
+)
+
+// archiveDateLayout is the layout of the {date} token
+const archiveDateLayout = "2006-01-02"
+
+var archiveTokens = regexp.MustCompile(`\{(name|date|index)\}`)
+
+// rename renames the log file and the rotated log files. It's a variable so that the tests
+// can make the rotation fail midway.
+var rename = os.Rename
+
+// datedArchive is a rotated log file found by its dated name
+type datedArchive struct {
+	name  string
+	date  string
+	index int
+}
+
+// checkArchivePattern returns an error if the archive pattern can't name distinct archives
+func checkArchivePattern(pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+	if strings.ContainsRune(pattern, '/') || strings.ContainsRune(pattern, filepath.Separator) {
+		return &Error{fmt.Sprintf("Invalid archive pattern %s: it must be a file name\n", pattern)}
+	}
+	if !strings.Contains(pattern, ArchiveIndexToken) && !strings.Contains(pattern, ArchiveDateToken) {
+		return &Error{fmt.Sprintf("Invalid archive pattern %s: it must contain %s or %s\n",
+			pattern, ArchiveIndexToken, ArchiveDateToken)}
+	}
+	return nil
+}
+
+// archives returns the names of the rotated log files, from the newest to the oldest
+func (log *Logger) archives() []string {
+	if log.fileName == "" {
+		return nil
+	}
+	if log.archiveDated {
+		archives := log.datedArchives()
+		names := make([]string, len(archives))
+		for i, archive := range archives {
+			names[i] = archive.name
+		}
+		return names
+	}
+
+	var names []string
+	for i := 1; ; i++ {
+		name := log.archiveName(i)
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return names
+		}
+		names = append(names, name)
+	}
+}
+
+// archiveName returns the name of the numbered rotated log file with the number index
+func (log *Logger) archiveName(index int) string {
+	if log.archivePattern == "" {
+		return fmt.Sprintf("%s.%d%s", log.fileName, index, log.archiveExtension)
+	}
+	return log.expandArchivePattern("", index)
+}
+
+// nextDatedArchiveName returns the name of the dated rotated log file to compress the log
+// file rotated now to. Without an index it's the archive of the day, to which the log file is
+// appended if it already exists.
+func (log *Logger) nextDatedArchiveName(now time.Time) string {
+	if log.utc {
+		now = now.UTC()
+	}
+	date := now.Format(archiveDateLayout)
+	index := 1
+	for _, archive := range log.datedArchives() {
+		if archive.date == date && archive.index >= index {
+			index = archive.index + 1
+		}
+	}
+	return log.expandArchivePattern(date, index)
+}
+
+// expandArchivePattern returns the path of the rotated log file named by the archive pattern.
+// The {name} token is the name of the log file without its extension.
+func (log *Logger) expandArchivePattern(date string, index int) string {
+	name := log.archiveBaseName()
+	expanded := archiveTokens.ReplaceAllStringFunc(log.archivePattern, func(token string) string {
+		switch token {
+		case ArchiveNameToken:
+			return name
+		case ArchiveDateToken:
+			return date
+		}
+		return strconv.Itoa(index)
+	})
+	return filepath.Join(filepath.Dir(log.fileName), expanded+log.archiveExtension)
+}
+
+// archiveBaseName returns the name of the log file without its directory and extension
+func (log *Logger) archiveBaseName() string {
+	name := filepath.Base(log.fileName)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// datedArchives returns the rotated log files matching the dated archive pattern, from the
+// newest to the oldest
+func (log *Logger) datedArchives() []datedArchive {
+	var expression strings.Builder
+	var tokens []string
+	expression.WriteString("^")
+	last := 0
+	for _, location := range archiveTokens.FindAllStringIndex(log.archivePattern, -1) {
+		expression.WriteString(regexp.QuoteMeta(log.archivePattern[last:location[0]]))
+		switch token := log.archivePattern[location[0]:location[1]]; token {
+		case ArchiveNameToken:
+			expression.WriteString(regexp.QuoteMeta(log.archiveBaseName()))
+		case ArchiveDateToken:
+			expression.WriteString(`(\d{4}-\d{2}-\d{2})`)
+			tokens = append(tokens, token)
+		default:
+			expression.WriteString(`(\d+)`)
+			tokens = append(tokens, token)
+		}
+		last = location[1]
+	}
+	expression.WriteString(regexp.QuoteMeta(log.archivePattern[last:]+log.archiveExtension) + "$")
+	matcher := regexp.MustCompile(expression.String())
+
+	dir := filepath.Dir(log.fileName)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.reportError("Failed to list the rotated log files in %s. Error: %s\n", dir, err)
+		return nil
+	}
+	var archives []datedArchive
+	for _, file := range files {
+		match := matcher.FindStringSubmatch(file.Name())
+		if match == nil || file.IsDir() {
+			continue
+		}
+		archive := datedArchive{name: filepath.Join(dir, file.Name())}
+		for i, token := range tokens {
+			if token == ArchiveDateToken {
+				archive.date = match[i+1]
+			} else {
+				archive.index, _ = strconv.Atoi(match[i+1])
+			}
+		}
+		archives = append(archives, archive)
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		if archives[i].date != archives[j].date {
+			return archives[i].date > archives[j].date
+		}
+		return archives[i].index > archives[j].index
+	})
+	return archives
+}
+
+// recoverRotation finishes a rotation interrupted by a crash, typically a power cycle. The
+// rotated log file left uncompressed is compressed, replacing the archive being compressed
+// from it, if any, which is incomplete. If the crash happened after the archives were
+// renumbered but before the log file was rotated, they are renumbered back so that none is
+// lost. Rotated log files that aren't compressed are archives themselves, and left as they are.
+func (log *Logger) recoverRotation() {
+	rotatedFileName := log.fileName + ".1"
+	uncompressedArchive := log.compressionFormat == NoneCompression && !log.archiveDated
+	if _, err := os.Stat(rotatedFileName); err == nil && !uncompressedArchive {
+		archiveName := log.archiveName(1)
+		if log.archiveDated {
+			archiveName = log.nextDatedArchiveName(time.Now())
+		} else if _, err := os.Stat(archiveName); err == nil {
+			os.Remove(archiveName)
+		}
+		log.compressFile(rotatedFileName, archiveName)
+		return
+	}
+
+	if log.archiveDated {
+		return
+	}
+	if _, err := os.Stat(log.archiveName(1)); !os.IsNotExist(err) {
+		return
+	}
+	for i := 2; ; i++ {
+		if _, err := os.Stat(log.archiveName(i)); os.IsNotExist(err) {
+			return
+		}
+		if err := rename(log.archiveName(i), log.archiveName(i-1)); err != nil {
+			log.reportError("Failed to rename compressed log file %s. Error: %s\n", log.archiveName(i), err)
+			return
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package logger
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// AddFileCategory adds a category of entries written to their own log file, for example the
+// security audit entries to audit.log, and returned by Category. The category's logger is
+// initialized with the parameters, except that it writes to fileName (the .log extension
+// is optional), by default in the directory of the logger's log file, only to the file and
+// with the default maintenance interval.
+// Its log file is rotated and compressed independently, according to the parameters, but
+// its level is always the logger's level.
+// Categories are stopped, flushed, synced and reopened with the logger. Adding a category
+// to the Discard logger does nothing, its Category is the Discard logger itself.
+func (log *Logger) AddFileCategory(name string, fileName string, parameters Parameters) error {
+	if log.discard {
+		return nil
+	}
+	log.categoryMutex.Lock()
+	defer log.categoryMutex.Unlock()
+	if _, exists := log.categories[name]; exists {
+		return &Error{fmt.Sprintf("Log category %s is already defined\n", name)}
+	}
+
+	parameters.FileName = strings.TrimSuffix(fileName, ".log")
+	log.lock()
+	logFileName := log.fileName
+	log.unLock()
+	if parameters.RootPath == "" && logFileName != "" {
+		parameters.RootPath = filepath.Dir(logFileName)
+	}
+	if parameters.Destinations == "" {
+		parameters.Destinations = "file"
+	}
+	if parameters.MaintenanceInterval == 0 {
+		parameters.MaintenanceInterval = defaultMaintenanceInterval
+	}
+	parameters.Level = logLevelNames[NONE]
+	if level := log.GetLevel(); validLevel(level) {
+		parameters.Level = logLevelNames[level]
+	}
+
+	category := &Logger{parent: log}
+	if err := category.Init(parameters); err != nil {
+		return err
+	}
+	if log.categories == nil {
+		log.categories = make(map[string]*Logger)
+	}
+	log.categories[name] = category
+	return nil
+}
+
+// Category returns the logger of a category added with AddFileCategory, or the logger itself
+// if there is no such category, so that the entries aren't lost
+func (log *Logger) Category(name string) *Logger {
+	log.categoryMutex.Lock()
+	defer log.categoryMutex.Unlock()
+	if category, found := log.categories[name]; found {
+		return category
+	}
+	return log
+}
+
+// eachCategory calls f with the logger of each category
+func (log *Logger) eachCategory(f func(category *Logger)) {
+	log.categoryMutex.Lock()
+	categories := make([]*Logger, 0, len(log.categories))
+	for _, category := range log.categories {
+		categories = append(categories, category)
+	}
+	log.categoryMutex.Unlock()
+	for _, category := range categories {
+		f(category)
+	}
+}
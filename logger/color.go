@@ -0,0 +1,45 @@
+package logger
+
+import (
+	"io"
+	"os"
+)
+
+// ANSI colors of the levels, indexed by level
+var logLevelColor = []string{"", "\x1b[32m", "\x1b[31m", "\x1b[31m", "\x1b[33m", "\x1b[36m", "\x1b[90m", "\x1b[90m"}
+
+const colorReset = "\x1b[0m"
+
+// colorWriter colors each line written to it with the color of the level of the entry being
+// written. The level is set, under the logger's lock, before each entry is written.
+type colorWriter struct {
+	writer io.Writer
+	level  int
+}
+
+func (w *colorWriter) Write(p []byte) (int, error) {
+	if w.level < 0 || w.level >= len(logLevelColor) || logLevelColor[w.level] == "" {
+		return w.writer.Write(p)
+	}
+
+	colored := make([]byte, 0, len(p)+len(logLevelColor[w.level])+len(colorReset))
+	colored = append(colored, logLevelColor[w.level]...)
+	if len(p) > 0 && p[len(p)-1] == '\n' {
+		colored = append(colored, p[:len(p)-1]...)
+		colored = append(colored, colorReset...)
+		colored = append(colored, '\n')
+	} else {
+		colored = append(colored, p...)
+		colored = append(colored, colorReset...)
+	}
+	if _, err := w.writer.Write(colored); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// isTerminal checks if a file is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,92 @@
+package logger
+
+import (
+	"context"
+	"sync"
+)
+
+// CorrelationIDField is the name of the field the correlation ID of a context is logged as
+const CorrelationIDField = "correlationID"
+
+type contextKey int
+
+const correlationIDKey contextKey = 0
+
+// contextFieldKeys maps the names of the fields logged by the Ctx methods to the keys
+// of their values in the context
+var contextFieldKeys = map[string]interface{}{CorrelationIDField: correlationIDKey}
+var contextFieldsMutex sync.RWMutex
+
+// WithCorrelationID returns a copy of the context carrying the correlation ID, which is
+// logged with every message logged with the context
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey, id)
+}
+
+// CorrelationID returns the correlation ID carried by the context, if any
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey).(string)
+	return id
+}
+
+// RegisterContextField registers a context value to be logged as a field by the Ctx methods,
+// in addition to the correlation ID. The value is looked up in the context with key.
+func RegisterContextField(name string, key interface{}) {
+	contextFieldsMutex.Lock()
+	contextFieldKeys[name] = key
+	contextFieldsMutex.Unlock()
+}
+
+// contextFields returns the registered fields carried by the context
+func contextFields(ctx context.Context) map[string]interface{} {
+	if ctx == nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	contextFieldsMutex.RLock()
+	defer contextFieldsMutex.RUnlock()
+	for name, key := range contextFieldKeys {
+		if value := ctx.Value(key); value != nil {
+			if fields == nil {
+				fields = make(map[string]interface{})
+			}
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+// StatusCtx logs with the fields carried by the context, see WithCorrelationID
+func (log *Logger) StatusCtx(ctx context.Context, format string, a ...interface{}) {
+	log.printf(STATUS, contextFields(ctx), format, a...)
+}
+
+// FatalCtx logs with the fields carried by the context, see WithCorrelationID
+func (log *Logger) FatalCtx(ctx context.Context, format string, a ...interface{}) {
+	log.printf(FATAL, contextFields(ctx), format, a...)
+}
+
+// ErrorCtx logs with the fields carried by the context, see WithCorrelationID
+func (log *Logger) ErrorCtx(ctx context.Context, format string, a ...interface{}) {
+	log.printf(ERROR, contextFields(ctx), format, a...)
+}
+
+// WarningCtx logs with the fields carried by the context, see WithCorrelationID
+func (log *Logger) WarningCtx(ctx context.Context, format string, a ...interface{}) {
+	log.printf(WARNING, contextFields(ctx), format, a...)
+}
+
+// InfoCtx logs with the fields carried by the context, see WithCorrelationID
+func (log *Logger) InfoCtx(ctx context.Context, format string, a ...interface{}) {
+	log.printf(INFO, contextFields(ctx), format, a...)
+}
+
+// DebugCtx logs with the fields carried by the context, see WithCorrelationID
+func (log *Logger) DebugCtx(ctx context.Context, format string, a ...interface{}) {
+	log.printf(DEBUG, contextFields(ctx), format, a...)
+}
+
+// TraceCtx logs with the fields carried by the context, see WithCorrelationID
+func (log *Logger) TraceCtx(ctx context.Context, format string, a ...interface{}) {
+	log.printf(TRACE, contextFields(ctx), format, a...)
+}
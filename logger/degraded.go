@@ -0,0 +1,133 @@
+package logger
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxFileWriteFailures is the number of consecutive failed writes to the log file after which
+// the logger switches to the degraded mode
+const maxFileWriteFailures = 3
+
+// fileWriter writes to the log file, through the buffer when buffering. When the writes keep
+// failing, typically because the file system is full or read-only, the logger switches to
+// the degraded mode: it stops writing to the log file until the next maintenance check, when
+// it tries again. With Parameters.StdoutFallback, the lines that can't be written to the log
+// file are written to stdout instead if the log file is the only destination.
+// Write never fails, so that the line is still written to the other destinations. It's
+// called with the logger locked.
+type fileWriter struct {
+	log *Logger
+}
+
+func (w fileWriter) Write(p []byte) (int, error) {
+	log := w.log
+	if !log.degraded && log.CurrentFile != nil {
+		var err error
+		if log.buffer != nil {
+			_, err = log.buffer.Write(p)
+		} else {
+			_, err = log.CurrentFile.Write(p)
+		}
+		if err == nil {
+			log.writeFailures = 0
+			return len(p), nil
+		}
+		log.fileWriteFailed(err)
+	}
+	if log.stdoutFallback && !log.Stdout && log.Syslog == nil {
+		log.stdoutWriter().Write(p)
+	}
+	return len(p), nil
+}
+
+// fileWriteFailed records a failed write to the log file, and switches to the degraded mode
+// after maxFileWriteFailures consecutive failures. Must be called with the logger locked.
+func (log *Logger) fileWriteFailed(err error) {
+	log.setLastError(&Error{fmt.Sprintf("Failed to write to log file %s. Error: %s\n", log.CurrentFile.Name(), err)})
+	if log.writeFailures++; log.writeFailures < maxFileWriteFailures || log.degraded {
+		return
+	}
+	log.degraded = true
+
+	// Tell the other destinations, since the line won't be in the log file
+	line := log.formatEntry(time.Now(), logLevelNames[ERROR], log.levelPrefix(ERROR), nil,
+		"Stopped writing to log file %s until the next maintenance check. Error: %s\n", log.CurrentFile.Name(), err)
+	if log.Stdout || (log.stdoutFallback && log.Syslog == nil) {
+		log.stdoutWriter().Write([]byte(line))
+	}
+	if log.Syslog != nil {
+		if isLeveledSyslog(log.Syslog) {
+			log.writeSyslog(ERROR, line)
+		} else {
+			log.Syslog.Write([]byte(line))
+		}
+	}
+}
+
+// retryFile leaves the degraded mode, to try writing to the log file again. Must be called
+// with the logger locked.
+func (log *Logger) retryFile() {
+	if !log.degraded {
+		return
+	}
+	log.degraded = false
+	log.writeFailures = 0
+	if log.buffer != nil && log.CurrentFile != nil {
+		// A failed buffer keeps failing, and its content is lost anyway
+		log.buffer.Reset(log.CurrentFile)
+	}
+}
+
+// LastError returns the last error writing to, rotating or compressing the log file, or nil
+// if there was none. Whether the logger currently writes to the log file is given by Degraded.
+func (log *Logger) LastError() error {
+	log.errorMutex.Lock()
+	defer log.errorMutex.Unlock()
+	return log.lastError
+}
+
+// Degraded returns true if the logger stopped writing to the log file because the writes
+// kept failing, until the next maintenance check
+func (log *Logger) Degraded() bool {
+	log.lock()
+	defer log.unLock()
+	return log.degraded
+}
+
+// setLastError records the last error of the log file
+func (log *Logger) setLastError(err error) {
+	log.errorMutex.Lock()
+	log.lastError = err
+	log.errorMutex.Unlock()
+}
+
+// OnInternalError sets a function called with the errors of the log file's maintenance, such
+// as failing to rotate or compress the log file, instead of logging them as errors. The
+// function is called from the logger's goroutines, with the logger unlocked, and it may log.
+func (log *Logger) OnInternalError(handler func(error)) {
+	log.errorMutex.Lock()
+	log.errorHandler = handler
+	log.errorMutex.Unlock()
+}
+
+// reportError reports an error of the log file's maintenance, see internalError
+func (log *Logger) reportError(format string, a ...interface{}) {
+	log.internalError(&Error{fmt.Sprintf(format, a...)})
+}
+
+// internalError records an error of the log file's maintenance as the last error, and passes
+// it to the handler set with OnInternalError, or else logs it. Must be called with the logger
+// unlocked.
+func (log *Logger) internalError(err error) {
+	log.errorMutex.Lock()
+	log.lastError = err
+	handler := log.errorHandler
+	log.errorMutex.Unlock()
+
+	if handler != nil {
+		handler(err)
+		return
+	}
+	log.Error("%s", err.Error())
+}
@@ -0,0 +1,103 @@
+package logger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what f writes to stdout
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	f()
+	w.Close()
+	output, _ := ioutil.ReadAll(r)
+	r.Close()
+	return string(output)
+}
+
+func TestLoggingAfterStop(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback bool
+	}{
+		{"without fallback", false},
+		{"with fallback", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := &Logger{}
+			parameters := testParameters(t, "file")
+			parameters.StdoutFallback = test.fallback
+			if err := log.Init(parameters); err != nil {
+				t.Fatal(err)
+			}
+			log.Info("before")
+			log.Stop()
+			output := captureStdout(t, func() {
+				for i := 0; i < maxFileWriteFailures+1; i++ {
+					log.Info("after")
+				}
+			})
+
+			if output != "" {
+				t.Errorf("stdout = %q, want nothing", output)
+			}
+			content := readLog(t, filepath.Join(parameters.RootPath, "test.log"))
+			if !strings.Contains(content, "before") || strings.Contains(content, "after") {
+				t.Errorf("log file = %q, want only the entry logged before Stop", content)
+			}
+			if log.LastError() != nil || log.Degraded() {
+				t.Errorf("logging after Stop failed writing: %v", log.LastError())
+			}
+		})
+	}
+}
+
+func TestDegradedFallback(t *testing.T) {
+	tests := []struct {
+		name       string
+		fallback   bool
+		wantStdout bool
+	}{
+		{"without fallback", false, false},
+		{"with fallback", true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := &Logger{}
+			parameters := testParameters(t, "file")
+			parameters.StdoutFallback = test.fallback
+			if err := log.Init(parameters); err != nil {
+				t.Fatal(err)
+			}
+			defer log.Stop()
+			// Writing to the closed file fails like writing to a full file system
+			log.CurrentFile.Close()
+			output := captureStdout(t, func() {
+				for i := 0; i < maxFileWriteFailures+1; i++ {
+					log.Info("line %d", i)
+				}
+			})
+
+			if !log.Degraded() || log.LastError() == nil {
+				t.Errorf("Degraded = %v, LastError = %v, want the degraded mode", log.Degraded(), log.LastError())
+			}
+			if got := strings.Contains(output, "line 0"); got != test.wantStdout {
+				t.Errorf("lines written to stdout = %v, want %v: %q", got, test.wantStdout, output)
+			}
+			if got := strings.Contains(output, "Stopped writing to log file"); got != test.wantStdout {
+				t.Errorf("degraded mode announced on stdout = %v, want %v: %q", got, test.wantStdout, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package logger
+
+// Entry a set of fields logged along with every message logged through it.
+// An Entry is never modified once created, so it is safe to reuse it and to
+// share it between goroutines.
+type Entry struct {
+	log    *Logger
+	fields map[string]interface{}
+}
+
+// WithFields returns an Entry that logs the fields with every message. In the text
+// format the fields are appended to the message as key=value pairs, in the JSON
+// format each field is added to the JSON object.
+func (log *Logger) WithFields(fields map[string]interface{}) *Entry {
+	return &Entry{log: log, fields: copyFields(nil, fields)}
+}
+
+// WithFields returns a new Entry with the fields added to the entry's fields
+func (entry *Entry) WithFields(fields map[string]interface{}) *Entry {
+	return &Entry{log: entry.log, fields: copyFields(entry.fields, fields)}
+}
+
+func copyFields(base map[string]interface{}, fields map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(base)+len(fields))
+	for key, value := range base {
+		result[key] = value
+	}
+	for key, value := range fields {
+		result[key] = value
+	}
+	return result
+}
+
+// Status log
+func (entry *Entry) Status(format string, a ...interface{}) {
+	entry.log.printf(STATUS, entry.fields, format, a...)
+}
+
+// Fatal log
+func (entry *Entry) Fatal(format string, a ...interface{}) {
+	entry.log.printf(FATAL, entry.fields, format, a...)
+}
+
+// Error log
+func (entry *Entry) Error(format string, a ...interface{}) {
+	entry.log.printf(ERROR, entry.fields, format, a...)
+}
+
+// Warning log
+func (entry *Entry) Warning(format string, a ...interface{}) {
+	entry.log.printf(WARNING, entry.fields, format, a...)
+}
+
+// Info log
+func (entry *Entry) Info(format string, a ...interface{}) {
+	entry.log.printf(INFO, entry.fields, format, a...)
+}
+
+// Debug log
+func (entry *Entry) Debug(format string, a ...interface{}) {
+	entry.log.printf(DEBUG, entry.fields, format, a...)
+}
+
+// Trace log
+func (entry *Entry) Trace(format string, a ...interface{}) {
+	entry.log.printf(TRACE, entry.fields, format, a...)
+}
+
+// Log logs at the specified level, typically a level registered with RegisterLevel
+func (entry *Entry) Log(level int, format string, a ...interface{}) {
+	entry.log.printf(level, entry.fields, format, a...)
+}
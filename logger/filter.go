@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// FilterWriter is an io.Writer that writes to another writer only the lines at or above a
+// level, for example to keep only the warnings and errors of a noisy library. The level
+// of a line is given by the first level prefix it contains, such as "WARNING: ", the same
+// prefixes the logger writes. Lines without a level prefix are always written.
+// Lines split across several writes are reassembled before being filtered, and a line
+// is only written once its newline was written, or on Flush.
+type FilterWriter struct {
+	writer  io.Writer
+	level   int
+	mutex   sync.Mutex
+	pending []byte
+}
+
+// NewFilterWriter returns a FilterWriter writing to w the lines at or above the level,
+// for example logger.WARNING for the WARNING, ERROR, FATAL and STATUS lines
+func NewFilterWriter(w io.Writer, level int) *FilterWriter {
+	return &FilterWriter{writer: w, level: level}
+}
+
+func (f *FilterWriter) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.pending = append(f.pending, p...)
+	for {
+		end := bytes.IndexByte(f.pending, '\n')
+		if end == -1 {
+			break
+		}
+		line := f.pending[:end+1]
+		if f.keep(line) {
+			if _, err := f.writer.Write(line); err != nil {
+				f.pending = f.pending[end+1:]
+				return len(p), err
+			}
+		}
+		f.pending = f.pending[end+1:]
+	}
+	if len(f.pending) == 0 {
+		f.pending = nil
+	}
+	return len(p), nil
+}
+
+// Flush filters and writes the last line, if it wasn't terminated by a newline
+func (f *FilterWriter) Flush() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	line := f.pending
+	f.pending = nil
+	if len(line) == 0 || !f.keep(line) {
+		return nil
+	}
+	_, err := f.writer.Write(line)
+	return err
+}
+
+// keep returns true if the line has no level prefix, or a level at or above the threshold
+func (f *FilterWriter) keep(line []byte) bool {
+	found := -1
+	position := len(line)
+	for level := STATUS; level < len(logLevelPrefix); level++ {
+		if index := bytes.Index(line[:position], []byte(logLevelPrefix[level])); index != -1 {
+			found = level
+			position = index
+		}
+	}
+	return found == -1 || logLevelThreshold[found] <= f.level
+}
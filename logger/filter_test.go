@@ -0,0 +1,114 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFilterWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		level    int
+		writes   []string
+		flush    bool
+		expected string
+	}{
+		{
+			name:     "whole lines",
+			level:    WARNING,
+			writes:   []string{"t ERROR: e\n", "t INFO: i\n", "t WARNING: w\n", "t DEBUG: d\n", "t STATUS: s\n"},
+			expected: "t ERROR: e\nt WARNING: w\nt STATUS: s\n",
+		},
+		{
+			name:     "several lines in one write",
+			level:    WARNING,
+			writes:   []string{"t INFO: i\nt ERROR: e\nt DEBUG: d\n"},
+			expected: "t ERROR: e\n",
+		},
+		{
+			name:     "line split across writes",
+			level:    WARNING,
+			writes:   []string{"t WARN", "ING: disk ", "almost full", "\nt IN", "FO: ok\n"},
+			expected: "t WARNING: disk almost full\n",
+		},
+		{
+			name:     "byte by byte",
+			level:    ERROR,
+			writes:   []string{"t", " ", "E", "R", "R", "O", "R", ":", " ", "x", "\n", "t", " ", "I", "N", "F", "O", ":", " ", "y", "\n"},
+			expected: "t ERROR: x\n",
+		},
+		{
+			name:     "partial line held until its newline",
+			level:    WARNING,
+			writes:   []string{"t ERROR: e\nt ERROR: partial"},
+			expected: "t ERROR: e\n",
+		},
+		{
+			name:     "partial line written on flush",
+			level:    WARNING,
+			writes:   []string{"t ERROR: e\nt ERROR: partial"},
+			flush:    true,
+			expected: "t ERROR: e\nt ERROR: partial",
+		},
+		{
+			name:     "partial line filtered on flush",
+			level:    WARNING,
+			writes:   []string{"t DEBUG: partial"},
+			flush:    true,
+			expected: "",
+		},
+		{
+			name:     "first prefix wins",
+			level:    WARNING,
+			writes:   []string{"t INFO: got ERROR: from peer\n", "t ERROR: got INFO: from peer\n"},
+			expected: "t ERROR: got INFO: from peer\n",
+		},
+		{
+			name:     "lines without a prefix",
+			level:    ERROR,
+			writes:   []string{"panic: oops\n", "\n"},
+			expected: "panic: oops\n\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b bytes.Buffer
+			f := NewFilterWriter(&b, test.level)
+			for _, write := range test.writes {
+				if n, err := f.Write([]byte(write)); n != len(write) || err != nil {
+					t.Fatalf("Write returned %d, %v", n, err)
+				}
+			}
+			if test.flush {
+				if err := f.Flush(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if b.String() != test.expected {
+				t.Errorf("Wrote %q, expected %q", b.String(), test.expected)
+			}
+		})
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("failed")
+}
+
+func TestFilterWriterError(t *testing.T) {
+	f := NewFilterWriter(failingWriter{}, WARNING)
+	if _, err := f.Write([]byte("t ERROR: e\nt ERROR: f\n")); err == nil {
+		t.Error("The error of the writer wasn't returned")
+	}
+	// The line that failed isn't written again, the next one is
+	if _, err := f.Write(nil); err == nil {
+		t.Error("The line after the one that failed was dropped")
+	}
+	if _, err := f.Write([]byte("t INFO: filtered\n")); err != nil {
+		t.Errorf("A filtered line returned %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// textTimeFormat is the default timestamp format of the text formats, the same as the standard log package's
+const textTimeFormat = "2006/01/02 15:04:05"
+
+// loggerPackage is the import path of this package, as seen by the runtime (it may be vendored)
+var loggerPackage = reflect.TypeOf(Logger{}).PkgPath()
+
+// formatEntry formats an entry in the configured format. An empty level name means
+// the entry has no level. The text+json format is the text format, with the fields
+// appended as a JSON object rather than as key=value pairs. The entry ends with exactly
+// one newline, whether the message ends with none or several.
+func (log *Logger) formatEntry(t time.Time, levelName string, levelPrefix string, fields map[string]interface{},
+	format string, a ...interface{}) string {
+	if log.utc {
+		t = t.UTC()
+	}
+	var caller string
+	if log.includeCaller {
+		caller = callerLocation()
+	}
+
+	if log.format == JSONFormat {
+		if caller != "" {
+			withCaller := make(map[string]interface{}, len(fields)+1)
+			for key, value := range fields {
+				withCaller[key] = value
+			}
+			withCaller["caller"] = caller
+			fields = withCaller
+		}
+		return log.jsonLine(t, levelName, fmt.Sprintf(format, a...), fields) + "\n"
+	}
+
+	var msg string
+	if log.format == TextJSONFormat && len(fields) != 0 {
+		msg = strings.TrimRight(fmt.Sprintf(format, a...), "\n") + " " + jsonObject(fields)
+	} else {
+		msg = textMessage(fields, format, a...)
+	}
+	if caller != "" {
+		msg = caller + ": " + msg
+	}
+	timeFormat := log.timeFormat
+	if timeFormat == "" {
+		timeFormat = textTimeFormat
+	}
+	return log.prefix + t.Format(timeFormat) + " " + levelPrefix + strings.TrimRight(msg, "\n") + "\n"
+}
+
+// callerLocation returns the file:line of the first caller outside of this package and
+// its facade packages (log and trace), so the result is the same whichever API was used
+func callerLocation() string {
+	pc := make([]uintptr, 16)
+	n := runtime.Callers(3, pc)
+	frames := runtime.CallersFrames(pc[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, loggerPackage+".") &&
+			!strings.HasPrefix(frame.Function, loggerPackage+"/") {
+			return fmt.Sprintf("%s:%d", filepath.Base(frame.File), frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// textMessage formats a message for the text format. Fields, if any, are appended
+// as key=value pairs sorted by key.
+func textMessage(fields map[string]interface{}, format string, a ...interface{}) string {
+	msg := fmt.Sprintf(format, a...)
+	if len(fields) == 0 {
+		return msg
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(msg, "\n"))
+	for _, key := range sortedKeys(fields) {
+		value := fmt.Sprint(fields[key])
+		if strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&b, " %s=%s", key, value)
+	}
+	return b.String()
+}
+
+// jsonLine formats an entry as a single line JSON object. An empty level name is omitted.
+// The logger's prefix is written as the prefix field, or as the component field (without
+// the tracing marker) when Parameters.PrefixAsField is set.
+func (log *Logger) jsonLine(t time.Time, levelName string, msg string, fields map[string]interface{}) string {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	writeJSONField(&b, "time", t.Format(time.RFC3339))
+	if levelName != "" {
+		writeJSONField(&b, "level", levelName)
+	}
+	if log.prefixAsField {
+		if log.component != "" {
+			writeJSONField(&b, "component", log.component)
+		}
+	} else if prefix := strings.TrimSpace(log.prefix); prefix != "" {
+		writeJSONField(&b, "prefix", prefix)
+	}
+	writeJSONField(&b, "msg", strings.TrimRight(msg, "\n"))
+	for _, key := range sortedKeys(fields) {
+		writeJSONField(&b, key, fields[key])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// jsonObject formats fields as a JSON object with the keys sorted
+func jsonObject(fields map[string]interface{}) string {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for _, key := range sortedKeys(fields) {
+		writeJSONField(&b, key, fields[key])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func writeJSONField(b *bytes.Buffer, key string, value interface{}) {
+	if b.Len() > 1 {
+		b.WriteByte(',')
+	}
+	encodedKey, _ := json.Marshal(key)
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		encodedValue, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(encodedKey)
+	b.WriteByte(':')
+	b.Write(encodedValue)
+}
+
+func sortedKeys(fields map[string]interface{}) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
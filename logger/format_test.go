@@ -0,0 +1,283 @@
+package logger
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logLines initializes a logger writing to a file with the parameters, calls logging and
+// returns the lines of the log file
+func logLines(t *testing.T, parameters Parameters, logging func(log *Logger)) []string {
+	t.Helper()
+	log := &Logger{}
+	if err := log.Init(parameters); err != nil {
+		t.Fatal(err)
+	}
+	logging(log)
+	log.Stop()
+	content := readLog(t, filepath.Join(parameters.RootPath, parameters.FileName+".log"))
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+func TestLogCounters(t *testing.T) {
+	counters := map[string]int64{"sent": 3, "dropped": 0, "bytes.out": 1 << 40}
+	tests := []struct {
+		name          string
+		format        string
+		countersLevel string
+		expected      []string
+	}{
+		{"text", TextFormat, "", []string{"STATUS: counters", "bytes.out=1099511627776", "dropped=0", "sent=3"}},
+		{"text+json", TextJSONFormat, "INFO", []string{"INFO: counters", `"bytes.out":1099511627776`, `"dropped":0`, `"sent":3`}},
+		{"level not logged", TextFormat, "DEBUG", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parameters := testParameters(t, "file")
+			parameters.Format = test.format
+			parameters.CountersLevel = test.countersLevel
+			lines := logLines(t, parameters, func(log *Logger) { log.LogCounters(counters) })
+			if test.expected == nil {
+				if len(lines) != 1 || lines[0] != "" {
+					t.Errorf("Logged %q, expected nothing", lines)
+				}
+				return
+			}
+			if len(lines) != 1 {
+				t.Fatalf("Logged %d lines, expected 1: %q", len(lines), lines)
+			}
+			for _, expected := range test.expected {
+				if !strings.Contains(lines[0], expected) {
+					t.Errorf("%q doesn't contain %s", lines[0], expected)
+				}
+			}
+		})
+	}
+}
+
+func TestLogCountersJSON(t *testing.T) {
+	parameters := testParameters(t, "file")
+	parameters.Format = JSONFormat
+	counters := map[string]int64{"sent": 3, "dropped": 0, "bytes.out": 1 << 40}
+	lines := logLines(t, parameters, func(log *Logger) { log.LogCounters(counters) })
+	if len(lines) != 1 {
+		t.Fatalf("Logged %d lines, expected 1: %q", len(lines), lines)
+	}
+	var entry map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(lines[0]))
+	decoder.UseNumber()
+	if err := decoder.Decode(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["level"] != "STATUS" || entry["msg"] != "counters" {
+		t.Errorf("Unexpected entry %v", entry)
+	}
+	for name, value := range counters {
+		if number, ok := entry[name].(json.Number); !ok || number.String() != strconv.FormatInt(value, 10) {
+			t.Errorf("The counter %s is %v, expected %d", name, entry[name], value)
+		}
+	}
+}
+
+func TestPrefixAsField(t *testing.T) {
+	tests := []struct {
+		name          string
+		format        string
+		prefixAsField bool
+		fields        map[string]string
+		linePrefix    string
+	}{
+		{"json component", JSONFormat, true, map[string]string{"component": "sync", "msg": "hello"}, ""},
+		{"json prefix", JSONFormat, false, map[string]string{"prefix": "sync", "msg": "hello"}, ""},
+		{"text", TextFormat, true, nil, "sync "},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parameters := testParameters(t, "file")
+			parameters.Format = test.format
+			parameters.Prefix = "sync "
+			parameters.PrefixAsField = test.prefixAsField
+			lines := logLines(t, parameters, func(log *Logger) { log.Info("hello") })
+			if len(lines) != 1 {
+				t.Fatalf("Logged %d lines, expected 1: %q", len(lines), lines)
+			}
+			if test.format == TextFormat {
+				if !strings.HasPrefix(lines[0], test.linePrefix) || !strings.HasSuffix(lines[0], "INFO: hello") {
+					t.Errorf("%q doesn't have the prefix inline", lines[0])
+				}
+				return
+			}
+			var entry map[string]interface{}
+			if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range test.fields {
+				if entry[key] != value {
+					t.Errorf("%s is %v, expected %s", key, entry[key], value)
+				}
+			}
+			for _, key := range []string{"component", "prefix"} {
+				if _, expected := test.fields[key]; !expected && entry[key] != nil {
+					t.Errorf("Unexpected %s %v", key, entry[key])
+				}
+			}
+		})
+	}
+}
+
+func TestTextJSONFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  map[string]interface{}
+		message string
+		json    map[string]interface{}
+	}{
+		{"with fields", map[string]interface{}{"device": "edge-1", "retries": 2}, "connected",
+			map[string]interface{}{"device": "edge-1", "retries": float64(2)}},
+		{"message ending with a newline", map[string]interface{}{"quoted": `a "b"`}, "connected\n",
+			map[string]interface{}{"quoted": `a "b"`}},
+		{"without fields", nil, "connected", nil},
+		{"with empty fields", map[string]interface{}{}, "connected", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parameters := testParameters(t, "file")
+			parameters.Format = TextJSONFormat
+			lines := logLines(t, parameters, func(log *Logger) { log.WithFields(test.fields).Info(test.message) })
+			if len(lines) != 1 {
+				t.Fatalf("Logged %d lines, expected 1: %q", len(lines), lines)
+			}
+			index := strings.Index(lines[0], "INFO: connected")
+			if index < 0 {
+				t.Fatalf("%q isn't a text line", lines[0])
+			}
+			suffix := strings.TrimPrefix(lines[0][index:], "INFO: connected")
+			if test.json == nil {
+				if suffix != "" {
+					t.Errorf("Unexpected suffix %q", suffix)
+				}
+				return
+			}
+			var decoded map[string]interface{}
+			if !strings.HasPrefix(suffix, " {") || json.Unmarshal([]byte(suffix[1:]), &decoded) != nil {
+				t.Fatalf("The suffix %q isn't a JSON object", suffix)
+			}
+			if !reflect.DeepEqual(decoded, test.json) {
+				t.Errorf("Logged the fields %v, expected %v", decoded, test.json)
+			}
+		})
+	}
+}
+
+func TestLogAt(t *testing.T) {
+	eventTime := time.Date(2021, time.March, 4, 5, 6, 7, 0, time.FixedZone("CET", 3600))
+	tests := []struct {
+		name       string
+		format     string
+		timeFormat string
+		utc        bool
+		expected   string
+	}{
+		{"text", TextFormat, "", false, "2021/03/04 05:06:07 INFO: replayed"},
+		{"text in UTC", TextFormat, "", true, "2021/03/04 04:06:07 INFO: replayed"},
+		{"custom time format", TextFormat, time.RFC3339, false, "2021-03-04T05:06:07+01:00 INFO: replayed"},
+		{"json", JSONFormat, "", false, `{"time":"2021-03-04T05:06:07+01:00","level":"INFO","msg":"replayed"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parameters := testParameters(t, "file")
+			parameters.Format = test.format
+			parameters.TimeFormat = test.timeFormat
+			parameters.UTC = test.utc
+			lines := logLines(t, parameters, func(log *Logger) { log.LogAt(eventTime, INFO, "replayed") })
+			if len(lines) != 1 || lines[0] != test.expected {
+				t.Errorf("Logged %q, expected %q", lines, test.expected)
+			}
+		})
+	}
+}
+
+func TestShortLevels(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		shortLevels bool
+		expected    []string
+	}{
+		{"short", TextFormat, true, []string{"S status", "E error", "W warning", "I info", "D debug", "T trace"}},
+		{"full", TextFormat, false, []string{"STATUS: status", "ERROR: error", "WARNING: warning", "INFO: info",
+			"DEBUG: debug", "TRACE: trace"}},
+		{"json unaffected", JSONFormat, true, []string{`"level":"STATUS"`, `"level":"ERROR"`, `"level":"WARNING"`,
+			`"level":"INFO"`, `"level":"DEBUG"`, `"level":"TRACE"`}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parameters := testParameters(t, "file")
+			parameters.Format = test.format
+			parameters.ShortLevels = test.shortLevels
+			parameters.Level = "TRACE"
+			lines := logLines(t, parameters, func(log *Logger) {
+				log.Status("status")
+				log.Error("error")
+				log.Warning("warning")
+				log.Info("info")
+				log.Debug("debug")
+				log.Trace("trace")
+			})
+			if len(lines) != len(test.expected) {
+				t.Fatalf("Logged %d lines, expected %d: %q", len(lines), len(test.expected), lines)
+			}
+			for i, expected := range test.expected {
+				if !strings.Contains(lines[i], expected) {
+					t.Errorf("%q doesn't contain %q", lines[i], expected)
+				}
+			}
+		})
+	}
+}
+
+func TestTrailingNewline(t *testing.T) {
+	formats := []string{TextFormat, JSONFormat, TextJSONFormat}
+	tests := []struct {
+		name    string
+		message string
+		fields  map[string]interface{}
+	}{
+		{"no newline", "message", nil},
+		{"one newline", "message\n", nil},
+		{"two newlines", "message\n\n", nil},
+		{"no newline with fields", "message", map[string]interface{}{"key": "value"}},
+		{"one newline with fields", "message\n", map[string]interface{}{"key": "value"}},
+		{"two newlines with fields", "message\n\n", map[string]interface{}{"key": "value"}},
+	}
+	for _, format := range formats {
+		for _, test := range tests {
+			t.Run(format+" "+test.name, func(t *testing.T) {
+				parameters := testParameters(t, "file")
+				parameters.Format = format
+				log := &Logger{}
+				if err := log.Init(parameters); err != nil {
+					t.Fatal(err)
+				}
+				log.WithFields(test.fields).Info(test.message)
+				log.Info("next")
+				log.Stop()
+
+				content := readLog(t, filepath.Join(parameters.RootPath, "test.log"))
+				// Each entry is a single line ending with exactly one newline
+				lines := strings.SplitAfter(content, "\n")
+				if len(lines) != 3 || lines[2] != "" {
+					t.Fatalf("Logged %q, expected 2 lines", content)
+				}
+				if !strings.Contains(lines[0], "message") || !strings.Contains(lines[1], "next") {
+					t.Errorf("Logged %q, expected the message then next", content)
+				}
+			})
+		}
+	}
+}
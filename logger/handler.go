@@ -0,0 +1,70 @@
+package logger
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// levelResponse is the body returned by the level handler
+type levelResponse struct {
+	Level      string            `json:"level"`
+	LevelValue int               `json:"levelValue"`
+	Counts     map[string]uint64 `json:"counts,omitempty"`
+	Degraded   bool              `json:"degraded"`
+	LastError  string            `json:"lastError,omitempty"`
+}
+
+// LevelHandler returns an http.Handler to inspect and change the logger's level at runtime.
+// GET returns the current level and the logger's stats: the number of entries logged at each
+// level, whether it's in the degraded mode and its last error, if any. PUT and POST set the
+// level from the level query parameter, or from the request body, either as a plain level
+// name or as {"level": "name"}, and return the same.
+func LevelHandler(l *Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+
+		case http.MethodPut, http.MethodPost:
+			name := r.URL.Query().Get("level")
+			if name == "" {
+				body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1024))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				name = strings.TrimSpace(string(body))
+				if strings.HasPrefix(name, "{") {
+					var request levelResponse
+					if err := json.Unmarshal(body, &request); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					name = request.Level
+				}
+			}
+			if err := l.SetLevelString(name); err != nil {
+				http.Error(w, strings.TrimSpace(err.Error()), http.StatusBadRequest)
+				return
+			}
+
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		level := l.GetLevel()
+		response := levelResponse{Level: LevelName(level), LevelValue: level, Degraded: l.Degraded(),
+			Counts: make(map[string]uint64)}
+		for countLevel, count := range l.Counts() {
+			response.Counts[LevelName(countLevel)] = count
+		}
+		if err := l.LastError(); err != nil {
+			response.LastError = strings.TrimSpace(err.Error())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+}
@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLevelHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantLevel  string
+	}{
+		{"get", http.MethodGet, "/", "", http.StatusOK, "INFO"},
+		{"put query", http.MethodPut, "/?level=debug", "", http.StatusOK, "DEBUG"},
+		{"post body", http.MethodPost, "/", "warning", http.StatusOK, "WARNING"},
+		{"post json", http.MethodPost, "/", `{"level": "trace"}`, http.StatusOK, "TRACE"},
+		{"invalid level", http.MethodPut, "/?level=loud", "", http.StatusBadRequest, "INFO"},
+		{"invalid json", http.MethodPost, "/", `{"level":`, http.StatusBadRequest, "INFO"},
+		{"invalid method", http.MethodDelete, "/", "", http.StatusMethodNotAllowed, "INFO"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			log := &Logger{}
+			if err := log.InitWithWriter(&output, INFO); err != nil {
+				t.Fatal(err)
+			}
+			defer log.Stop()
+			log.Error("an error")
+			log.Info("an info")
+			log.Debug("not logged")
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+			LevelHandler(log).ServeHTTP(recorder, request)
+
+			if recorder.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, test.wantStatus)
+			}
+			if level := LevelName(log.GetLevel()); level != test.wantLevel {
+				t.Errorf("level = %s, want %s", level, test.wantLevel)
+			}
+			if test.wantStatus != http.StatusOK {
+				return
+			}
+			var response levelResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to decode %q: %s", recorder.Body.String(), err)
+			}
+			if response.Level != test.wantLevel || response.LevelValue != log.GetLevel() {
+				t.Errorf("response level = %s (%d), want %s", response.Level, response.LevelValue, test.wantLevel)
+			}
+			if response.Counts["ERROR"] != 1 || response.Counts["INFO"] != 1 || response.Counts["DEBUG"] != 0 {
+				t.Errorf("response counts = %v, want one ERROR and one INFO", response.Counts)
+			}
+			if response.Degraded || response.LastError != "" {
+				t.Errorf("response degraded = %v, last error = %q, want neither", response.Degraded, response.LastError)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package logger
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// hookQueueSize is the number of log events queued for the hooks. When the hooks fall behind
+// and the queue is full, the events are dropped rather than blocking the logging calls.
+const hookQueueSize = 1024
+
+// hookEvent is a logged message queued for the hooks
+type hookEvent struct {
+	level   int
+	message string
+}
+
+// AddHook adds a function called with the level and the message of every logged entry, for
+// example to count the entries per level or to forward the fatal errors to an alerting
+// system. The message is formatted like in the text format, without the timestamp, prefix,
+// level and trailing newline.
+// Hooks are called one at a time, in the order the entries were logged, from a goroutine of
+// the logger, so they don't slow down the logging calls. As a consequence they may be called
+// after the logging call returned, and entries are dropped for the hooks if they can't keep
+// up. A hook must not log with the logger it's added to, which would log recursively.
+func (log *Logger) AddHook(hook func(level int, message string)) {
+	log.hookMutex.Lock()
+	defer log.hookMutex.Unlock()
+
+	// The hooks are copied, so that the hooks goroutine can call a snapshot without locking
+	hooks := make([]func(int, string), len(log.hooks), len(log.hooks)+1)
+	copy(hooks, log.hooks)
+	log.hooks = append(hooks, hook)
+	atomic.StoreInt32(&log.hookCount, int32(len(log.hooks)))
+}
+
+// runHooks queues an entry for the hooks, if there are any
+func (log *Logger) runHooks(level int, fields map[string]interface{}, format string, a ...interface{}) {
+	if atomic.LoadInt32(&log.hookCount) == 0 {
+		return
+	}
+	event := hookEvent{level: level, message: strings.TrimRight(textMessage(fields, format, a...), "\n")}
+
+	log.hookMutex.Lock()
+	defer log.hookMutex.Unlock()
+	if log.hookEvents == nil {
+		log.hookEvents = make(chan hookEvent, hookQueueSize)
+		log.hooksDone = make(chan struct{})
+		go log.callHooks(log.hookEvents, log.hooksDone)
+	}
+	select {
+	case log.hookEvents <- event:
+	default:
+	}
+}
+
+// callHooks calls the hooks with the queued events, until the queue is closed
+func (log *Logger) callHooks(events chan hookEvent, done chan struct{}) {
+	defer close(done)
+	for event := range events {
+		log.hookMutex.Lock()
+		hooks := log.hooks
+		log.hookMutex.Unlock()
+		for _, hook := range hooks {
+			hook(event.level, event.message)
+		}
+	}
+}
+
+// stopHooks stops the hooks goroutine once the hooks were called with the queued events
+func (log *Logger) stopHooks() {
+	log.hookMutex.Lock()
+	events, done := log.hookEvents, log.hooksDone
+	log.hookEvents, log.hooksDone = nil, nil
+	if events != nil {
+		close(events)
+	}
+	log.hookMutex.Unlock()
+	if done != nil {
+		<-done
+	}
+}
@@ -0,0 +1,53 @@
+package logger
+
+import "sync/atomic"
+
+// Counts returns the number of entries logged at each level since the logger was created,
+// including the registered levels. Only the entries written to a destination are counted,
+// not those filtered out by the logging level.
+func (log *Logger) Counts() map[int]uint64 {
+	counts := make(map[int]uint64)
+	for level := STATUS; level < len(logLevelThreshold); level++ {
+		counts[level] = 0
+	}
+	levelCounts, _ := log.levelCounts.Load().([]*uint64)
+	for level, count := range levelCounts {
+		if count != nil && level != NONE {
+			counts[level] = atomic.LoadUint64(count)
+		}
+	}
+	return counts
+}
+
+// countEntry increments the count of entries logged at the level
+func (log *Logger) countEntry(level int) {
+	levelCounts, _ := log.levelCounts.Load().([]*uint64)
+	if level >= len(levelCounts) {
+		levelCounts = log.growLevelCounts(level)
+	}
+	atomic.AddUint64(levelCounts[level], 1)
+}
+
+// growLevelCounts adds the counts of the levels registered since the counts were allocated.
+// The counts are copied rather than modified, and keep their counters, so that countEntry
+// doesn't need to lock.
+func (log *Logger) growLevelCounts(level int) []*uint64 {
+	log.levelCountsMutex.Lock()
+	defer log.levelCountsMutex.Unlock()
+
+	levelCounts, _ := log.levelCounts.Load().([]*uint64)
+	if level < len(levelCounts) {
+		return levelCounts
+	}
+	size := len(logLevelThreshold)
+	if size <= level {
+		size = level + 1
+	}
+	grown := make([]*uint64, size)
+	copy(grown, levelCounts)
+	for i := len(levelCounts); i < size; i++ {
+		grown[i] = new(uint64)
+	}
+	log.levelCounts.Store(grown)
+	return grown
+}
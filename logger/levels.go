@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// logLevelThreshold is, for each level, the minimum logger level at which it is logged.
+// Built-in levels are their own threshold, custom levels have the numeric value they were
+// registered with.
+var logLevelThreshold = []int{NONE, STATUS, FATAL, ERROR, WARNING, INFO, DEBUG, TRACE}
+
+var registerMutex sync.Mutex
+
+// RegisterLevel registers a custom level and returns the value to log at it, for example with
+// Logger.Log. The value is never one of the built-in levels.
+//
+// numeric orders the custom level relative to the built-in levels: a message at the custom
+// level is logged whenever a message at the built-in level of the same value would be, and
+// its syslog priority and color are those of that level. For example a level that should
+// always be logged, like an audit trail, is registered with STATUS, and one that sorts with
+// the errors with ERROR. Setting the logger's level to the custom level's name is the same
+// as setting it to numeric. glogVerbosity is the glog verbosity from which the level is
+// written to glog.
+//
+// Levels must be registered before the loggers start logging, typically from an init function.
+func RegisterLevel(name string, numeric int, glogVerbosity int) (int, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if name == "" {
+		return 0, &Error{"Log level name is empty\n"}
+	}
+	if numeric < STATUS || numeric > TRACE {
+		return 0, &Error{fmt.Sprintf("Invalid numeric value %d of log level %s\n", numeric, name)}
+	}
+	if glogVerbosity < 0 {
+		return 0, &Error{fmt.Sprintf("Invalid glog verbosity %d of log level %s\n", glogVerbosity, name)}
+	}
+
+	registerMutex.Lock()
+	defer registerMutex.Unlock()
+	if _, exists := logLevels[name]; exists {
+		return 0, &Error{fmt.Sprintf("Log level %s is already defined\n", name)}
+	}
+
+	level := len(logLevelNames)
+	logLevels[name] = numeric
+	logLevelNames = append(logLevelNames, name)
+	logLevelPrefix = append(logLevelPrefix, name+": ")
+	logLevelShortPrefix = append(logLevelShortPrefix, name[:1]+" ")
+	logLevel2glog = append(logLevel2glog, glogVerbosity)
+	logLevel2syslog = append(logLevel2syslog, logLevel2syslog[numeric])
+	logLevelColor = append(logLevelColor, logLevelColor[numeric])
+	logLevelThreshold = append(logLevelThreshold, numeric)
+	return level, nil
+}
+
+// LevelName returns the name of a built-in or registered level, or an empty string if the
+// level is invalid
+func LevelName(level int) string {
+	if !validLevel(level) {
+		return ""
+	}
+	return logLevelNames[level]
+}
+
+// LevelValue returns the value of a level name, case insensitively, and false if there is
+// no such level. The value of a registered level's name is its numeric value, that is the
+// value to set the logger's level to.
+func LevelValue(name string) (int, bool) {
+	level, ok := logLevels[strings.ToUpper(strings.TrimSpace(name))]
+	return level, ok
+}
+
+// validLevel returns true if the level is a built-in or registered level
+func validLevel(level int) bool {
+	return level >= 0 && level < len(logLevelThreshold)
+}
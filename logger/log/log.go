@@ -1,6 +1,12 @@
 package log
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
 	"github.com/open-horizon/edge-utilities/logger"
 )
 
@@ -11,16 +17,61 @@ func Init(parameters logger.Parameters) error {
 	return log.Init(parameters)
 }
 
+// InitWithWriter initializes the logger to write to w, for tests
+func InitWithWriter(w io.Writer, level int) error {
+	return log.InitWithWriter(w, level)
+}
+
 // ParseDestinationsList parses a list of destinations
 func ParseDestinationsList(destinations string) ([]bool, bool) {
 	return log.ParseDestinationsList(destinations)
 }
 
+// Reopen closes and reopens the log file, for external log rotation
+func Reopen() error {
+	return log.Reopen()
+}
+
+// Flush commits the log to stable storage
+func Flush() {
+	log.Flush()
+}
+
+// Sync waits until the log files' maintenance in progress, including compressions, is done
+func Sync() {
+	log.Sync()
+}
+
 // Stop Logger
 func Stop() {
 	log.Stop()
 }
 
+// StopWithTimeout stops the logger, waiting at most for the timeout for the pending work
+func StopWithTimeout(timeout time.Duration) error {
+	return log.StopWithTimeout(timeout)
+}
+
+// SetWriters atomically replaces the set of writers
+func SetWriters(writers ...io.Writer) error {
+	return log.SetWriters(writers...)
+}
+
+// SetLevel changes the logging level at runtime
+func SetLevel(level int) {
+	log.SetLevel(level)
+}
+
+// SetLevelString changes the logging level at runtime, using the level's name
+func SetLevelString(name string) error {
+	return log.SetLevelString(name)
+}
+
+// GetLevel returns the current logging level
+func GetLevel() int {
+	return log.GetLevel()
+}
+
 // IsLogging checks if the logging level if higher or equal to the level parameter
 func IsLogging(level int) bool {
 	return log.IsLogging(level)
@@ -61,7 +112,218 @@ func Trace(format string, a ...interface{}) {
 	log.Trace(format, a...)
 }
 
+// Statusln logs the message verbatim, without interpreting it as a format
+func Statusln(message string) {
+	log.Statusln(message)
+}
+
+// Fatalln logs the message verbatim, without interpreting it as a format
+func Fatalln(message string) {
+	log.Fatalln(message)
+}
+
+// Errorln logs the message verbatim, without interpreting it as a format
+func Errorln(message string) {
+	log.Errorln(message)
+}
+
+// Warningln logs the message verbatim, without interpreting it as a format
+func Warningln(message string) {
+	log.Warningln(message)
+}
+
+// Infoln logs the message verbatim, without interpreting it as a format
+func Infoln(message string) {
+	log.Infoln(message)
+}
+
+// Debugln logs the message verbatim, without interpreting it as a format
+func Debugln(message string) {
+	log.Debugln(message)
+}
+
+// Traceln logs the message verbatim, without interpreting it as a format
+func Traceln(message string) {
+	log.Traceln(message)
+}
+
+// StatusCtx logs with the fields carried by the context
+func StatusCtx(ctx context.Context, format string, a ...interface{}) {
+	log.StatusCtx(ctx, format, a...)
+}
+
+// FatalCtx logs with the fields carried by the context
+func FatalCtx(ctx context.Context, format string, a ...interface{}) {
+	log.FatalCtx(ctx, format, a...)
+}
+
+// ErrorCtx logs with the fields carried by the context
+func ErrorCtx(ctx context.Context, format string, a ...interface{}) {
+	log.ErrorCtx(ctx, format, a...)
+}
+
+// WarningCtx logs with the fields carried by the context
+func WarningCtx(ctx context.Context, format string, a ...interface{}) {
+	log.WarningCtx(ctx, format, a...)
+}
+
+// InfoCtx logs with the fields carried by the context
+func InfoCtx(ctx context.Context, format string, a ...interface{}) {
+	log.InfoCtx(ctx, format, a...)
+}
+
+// DebugCtx logs with the fields carried by the context
+func DebugCtx(ctx context.Context, format string, a ...interface{}) {
+	log.DebugCtx(ctx, format, a...)
+}
+
+// TraceCtx logs with the fields carried by the context
+func TraceCtx(ctx context.Context, format string, a ...interface{}) {
+	log.TraceCtx(ctx, format, a...)
+}
+
+// Log logs at the specified level, typically a level registered with logger.RegisterLevel
+func Log(level int, format string, a ...interface{}) {
+	log.Log(level, format, a...)
+}
+
+// LogAt logs at the specified level with the timestamp t rather than the current time
+func LogAt(t time.Time, level int, format string, a ...interface{}) {
+	log.LogAt(t, level, format, a...)
+}
+
+// LogFunc logs the message returned by message at the specified level, calling message only if the level is logged
+func LogFunc(level int, message func() string) {
+	log.LogFunc(level, message)
+}
+
+// ErrorRateLimited logs an error at most once per every for the key
+func ErrorRateLimited(key string, every time.Duration, format string, a ...interface{}) {
+	log.ErrorRateLimited(key, every, format, a...)
+}
+
+// WarningRateLimited logs a warning at most once per every for the key
+func WarningRateLimited(key string, every time.Duration, format string, a ...interface{}) {
+	log.WarningRateLimited(key, every, format, a...)
+}
+
+// LastError returns the last error writing to, rotating or compressing the log file
+func LastError() error {
+	return log.LastError()
+}
+
+// Degraded returns true if the logger stopped writing to the log file because the writes kept failing
+func Degraded() bool {
+	return log.Degraded()
+}
+
+// OnInternalError sets a function called with the errors of the log file's maintenance instead of logging them
+func OnInternalError(handler func(error)) {
+	log.OnInternalError(handler)
+}
+
+// Counts returns the number of entries logged at each level
+func Counts() map[int]uint64 {
+	return log.Counts()
+}
+
+// AddHook adds a function called with the level and the message of every logged entry
+func AddHook(hook func(level int, message string)) {
+	log.AddHook(hook)
+}
+
+// AddFileCategory adds a category of entries written to their own log file
+func AddFileCategory(name string, fileName string, parameters logger.Parameters) error {
+	return log.AddFileCategory(name, fileName, parameters)
+}
+
+// Category returns the logger of a category added with AddFileCategory
+func Category(name string) *logger.Logger {
+	return log.Category(name)
+}
+
+// WithFields returns an Entry that logs the fields with every message
+func WithFields(fields map[string]interface{}) *logger.Entry {
+	return log.WithFields(fields)
+}
+
+// LogCounters logs a snapshot of counters
+func LogCounters(counters map[string]int64) {
+	log.LogCounters(counters)
+}
+
 // Dump a struct to the log
 func Dump(label string, a interface{}) {
 	log.Dump(label, a)
 }
+
+// DumpLevel dumps a struct to the log at the specified level
+func DumpLevel(level int, label string, a interface{}) {
+	log.DumpLevel(level, label, a)
+}
+
+// StackTrace will log the current stack trace
+func StackTrace() {
+	log.StackTraceDepth(1, 0)
+}
+
+// RecoverAndLog recovers from a panic and logs it with the stack trace, it must be deferred directly
+func RecoverAndLog(rethrow bool) {
+	if r := recover(); r != nil {
+		log.LogPanic(r, rethrow)
+	}
+}
+
+// StackTraceDepth will log the current stack trace of up to max frames, starting skip frames above the caller
+func StackTraceDepth(skip int, max int) {
+	log.StackTraceDepth(skip+1, max)
+}
+
+// Handle is an independently configured logger created by NewNamed, with the methods of
+// logger.Logger, for the components of a process that each own their logging configuration
+type Handle struct {
+	*logger.Logger
+	name string
+}
+
+var named = make(map[string]*Handle)
+var namedMutex sync.Mutex
+
+// NewNamed creates and initializes a logger independent of the package's logger and of the
+// other named loggers. The name is reserved until the handle is stopped.
+func NewNamed(name string, parameters logger.Parameters) (*Handle, error) {
+	namedMutex.Lock()
+	defer namedMutex.Unlock()
+	if _, exists := named[name]; exists {
+		return nil, &logger.Error{Message: fmt.Sprintf("Logger %s already exists\n", name)}
+	}
+	handle := &Handle{Logger: &logger.Logger{}, name: name}
+	if err := handle.Init(parameters); err != nil {
+		return nil, err
+	}
+	named[name] = handle
+	return handle, nil
+}
+
+// Stop stops the logger and releases its name
+func (handle *Handle) Stop() {
+	handle.Logger.Stop()
+	handle.release()
+}
+
+// StopWithTimeout stops the logger, waiting at most for the timeout for the pending work, and
+// releases its name
+func (handle *Handle) StopWithTimeout(timeout time.Duration) error {
+	err := handle.Logger.StopWithTimeout(timeout)
+	handle.release()
+	return err
+}
+
+// release releases the name of the logger
+func (handle *Handle) release() {
+	namedMutex.Lock()
+	if named[handle.name] == handle {
+		delete(named, handle.name)
+	}
+	namedMutex.Unlock()
+}
@@ -1,20 +1,26 @@
 package logger
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
+	"io/ioutil"
 	golog "log"
 	"log/syslog"
 	"os"
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/klauspost/compress/zstd"
 )
 
 // Parameters parameters for logger setup
@@ -27,23 +33,179 @@ type Parameters struct {
 	Prefix                   string
 	Level                    string
 	MaintenanceInterval      int16
+	MaxFileAgeHours          int
+	HeartbeatInterval        int
+	CountersLevel            string
+	Format                   string
+	PrefixAsField            bool
+	IncludeCaller            bool
+	ShortLevels              bool
+
+	// CompressionLevel is the gzip compression level of rotated log files, from gzip.BestSpeed
+	// to gzip.BestCompression. Zero uses gzip.DefaultCompression, and NoCompression stores
+	// the rotated logs in gzip files without compressing them.
+	CompressionLevel int
+
+	// MaxCompressedFileAgeDays, when set, keeps rotated logs for this many days. Older archives
+	// are deleted at each maintenance check, even when there are fewer than
+	// MaxCompressedFilesNumber of them, and younger archives are never deleted, even when
+	// there are more than MaxCompressedFilesNumber of them. In other words, when both are set
+	// the age limit takes precedence over the count limit.
+	MaxCompressedFileAgeDays int
+
+	// MaxTotalLogBytes, when set, is the maximum total size of the log file and all of the
+	// rotated log files. The oldest archives are deleted until the total fits, regardless of
+	// MaxCompressedFileAgeDays.
+	MaxTotalLogBytes int64
+
+	// CompressionFormat is the format of rotated log files, GzipCompression (the default,
+	// name.N.gz), ZstdCompression (name.N.zst) or NoneCompression, to keep them as plain text
+	// files (name.N) that can be read directly, without spending CPU on compressing them. For
+	// zstd the compression level is mapped to the closest zstd encoder level, and
+	// NoCompression uses the fastest one. Archives of the other formats are left untouched.
+	CompressionFormat string
+
+	// Colorize prefixes lines written to stdout with an ANSI color per level, when stdout is
+	// a terminal. Lines written to the other destinations are never colored.
+	Colorize bool
+
+	// VerifyDestinations checks at Init that the syslog destination is reachable, by
+	// connecting to it and writing a debug priority test message (a remote server is only
+	// connected to). If the destination is listed in RequiredDestinations Init fails,
+	// otherwise the destination is dropped and a warning is logged to the remaining
+	// destinations.
+	VerifyDestinations   bool
+	RequiredDestinations string
+
+	// SyncWrites opens the log file with O_SYNC, so every line is on stable storage before
+	// the logging call returns. This severely reduces logging throughput, and should only be
+	// used where durability of every line matters more than performance. BufferSize is
+	// ignored when it's set, as buffered lines would defeat it.
+	SyncWrites bool
+
+	// SyslogAddress, when set, sends the syslog destination to a remote syslog server instead
+	// of the local one. The address is host:port, optionally preceded by tcp:// (the default)
+	// or udp://. Listing syslog://host:port in Destinations is equivalent to setting it.
+	// SyslogFormat is the format of remote messages, RFC3164 (the default) or RFC5424.
+	// The messages are sent in the background, so that logging never waits for the server:
+	// while it's slow or unreachable up to SyslogBufferLines messages (256 by default) are
+	// queued and sent when the connection is reestablished, later messages are dropped.
+	SyslogAddress     string
+	SyslogFormat      string
+	SyslogBufferLines int
+
+	// TimeFormat is the layout, in the time package's reference time format, of the timestamps
+	// of the text formats, for example time.RFC3339. The default is the standard log package's
+	// 2006/01/02 15:04:05. The JSON format always uses RFC3339.
+	TimeFormat string
+
+	// UTC writes the timestamps in UTC rather than in the local time zone
+	UTC bool
+
+	// BufferSize, when set, buffers up to this many bytes of writes to the log file, to reduce
+	// the number of small writes that wear flash storage. The buffer is flushed at each
+	// maintenance interval, before the log file is rotated or reopened, and by Flush and Stop.
+	// The tradeoff is durability: the lines still in the buffer are lost if the process
+	// crashes or exits without calling Flush or Stop. Stdout and syslog are not buffered.
+	// FATAL lines are always flushed and committed to stable storage before the logging call
+	// returns, since the process is usually about to exit. The log file isn't buffered when
+	// SyncWrites is set.
+	BufferSize int
+
+	// FlushErrors gives ERROR lines the same guarantee as FATAL lines, of being flushed and
+	// committed to stable storage before the logging call returns
+	FlushErrors bool
+
+	// MaxFileSizeStr, when set, is the size the log file is rotated at, with a unit, for
+	// example "10MB", see ParseSize. It takes precedence over MaxFileSize, which is in KB.
+	MaxFileSizeStr string
+
+	// FileMode is the permissions of the log file and of the rotated log files, 0666 by
+	// default. DirMode is the permissions of RootPath when it's created, 0755 by default.
+	// Both are subject to the process umask.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// ArchivePattern is the name of the rotated log files, in the log file's directory and
+	// followed by the compression format's extension. {name} is replaced by the log file's
+	// name without its extension, {index} by a number and {date} by the rotation date, in
+	// the 2006-01-02 format. Without {date} the rotated log files are numbered from the
+	// newest, 1, to the oldest, like the default name.log.N. With {date} they keep their
+	// name, {index} numbering the rotations of the day, and without {index} the logs rotated
+	// during a day are all appended to the day's archive, for example with {name}-{date}.log
+	// to edge-2024-06-01.log.gz. The archive count and age limits apply to the archives
+	// matching the pattern. The default is the numbered name.log.N scheme.
+	ArchivePattern string
+
+	// StdoutFallback writes the lines that can't be written to the log file to stdout instead,
+	// when the log file is the only destination: while the logger is in the degraded mode, and
+	// if no log file can be opened after a rotation. Without it those lines are dropped.
+	StdoutFallback bool
 }
 
 // Logger information needed for a logger (or trace)
 type Logger struct {
 	Tracing                  bool
 	Logger                   *golog.Logger
-	Level                    int
+	level                    atomic.Int32
 	MaxFileSize              int64
 	MaxCompressedFilesNumber int
 	CurrentFile              *os.File
-	useLogger                bool
-	glog                     bool
+	useLogger                atomic.Bool
+	glog                     atomic.Bool
 	prefix                   string
 	Stdout                   bool
 	Syslog                   io.Writer
 	ticker                   *time.Ticker
-	lockChannel              chan int
+	done                     chan struct{}
+	mutex                    sync.Mutex
+	countersLevel            int
+	format                   string
+	prefixAsField            bool
+	includeCaller            bool
+	shortLevels              bool
+	colorWriter              *colorWriter
+	component                string
+	fileFlags                int
+	fileName                 string
+	maxFileAge               time.Duration
+	compressionLevel         int
+	compressionFormat        string
+	maxArchiveAge            time.Duration
+	maxTotalBytes            int64
+	archiveExtension         string
+	fileOpenTime             time.Time
+	rateLimits               map[string]*rateLimit
+	timeFormat               string
+	utc                      bool
+	buffer                   *bufio.Writer
+	flushErrors              bool
+	stateMutex               sync.Mutex
+	initialized              bool
+	maintenance              sync.WaitGroup
+	maintenanceMutex         sync.Mutex
+	compressions             sync.WaitGroup
+	compressing              chan struct{}
+	fileMode                 os.FileMode
+	archivePattern           string
+	archiveDated             bool
+	hooks                    []func(int, string)
+	hookCount                int32
+	hookMutex                sync.Mutex
+	hookEvents               chan hookEvent
+	hooksDone                chan struct{}
+	levelCounts              atomic.Value
+	levelCountsMutex         sync.Mutex
+	degraded                 bool
+	writeFailures            int
+	lastError                error
+	errorHandler             func(error)
+	errorMutex               sync.Mutex
+	parent                   *Logger
+	categories               map[string]*Logger
+	categoryMutex            sync.Mutex
+	discard                  bool
+	stdoutFallback           bool
 }
 
 // Error is the error struct used by the logger code
@@ -67,6 +229,33 @@ const (
 	TRACE   = 7
 )
 
+// Log formats
+const (
+	TextFormat     = "text"
+	JSONFormat     = "json"
+	TextJSONFormat = "text+json"
+)
+
+// Compression formats of rotated log files
+const (
+	GzipCompression = "gzip"
+	ZstdCompression = "zstd"
+	NoneCompression = "none"
+)
+
+// defaultMaintenanceInterval is the maintenance interval, in seconds, used when
+// Parameters.MaintenanceInterval isn't positive
+const defaultMaintenanceInterval = 30
+
+// Default permissions of the log files and of the log directory
+const (
+	defaultFileMode os.FileMode = 0666
+	defaultDirMode  os.FileMode = 0755
+)
+
+// NoCompression is the compression level to store rotated log files without compressing them
+const NoCompression = -3
+
 // Log destinations
 const (
 	FILE = iota
@@ -76,13 +265,18 @@ const (
 	destinationTypes
 )
 
+// processStart approximates the start time of the process
+var processStart = time.Now()
+
 var logLevels = map[string]int{
 	"NONE": NONE, "STATUS": STATUS, "FATAL": FATAL, "ERROR": ERROR,
 	"WARNING": WARNING, "INFO": INFO, "DEBUG": DEBUG, "TRACE": TRACE,
 	"XTRACE": TRACE,
 }
 
+var logLevelNames = []string{"NONE", "STATUS", "FATAL", "ERROR", "WARNING", "INFO", "DEBUG", "TRACE"}
 var logLevelPrefix = []string{"NONE: ", "STATUS: ", "FATAL: ", "ERROR: ", "WARNING: ", "INFO: ", "DEBUG: ", "TRACE: "}
+var logLevelShortPrefix = []string{"N ", "S ", "F ", "E ", "W ", "I ", "D ", "T "}
 var logLevel2glog = []int{0, 0, 0, 0, 0, 3, 5, 6}
 
 // meaning: STATUS, FATAL, ERROR and WARNING are "gloged" when glog verbosity >= 0 (i.e., always)
@@ -90,19 +284,116 @@ var logLevel2glog = []int{0, 0, 0, 0, 0, 3, 5, 6}
 //          DEBUG is "gloged" when glog verbosity >= 5
 //          TRACE is "gloged" when glog verbosity >= 6
 
-// Init Initialize Logger
+// Init Initialize Logger. Init and Stop are safe to call concurrently, and calling Init again
+// without calling Stop first is an error.
 func (log *Logger) Init(parameters Parameters) error {
+	log.stateMutex.Lock()
+	defer log.stateMutex.Unlock()
+	if log.initialized {
+		return &Error{"The logger is already initialized\n"}
+	}
+	if err := log.init(parameters); err != nil {
+		return err
+	}
+	log.initialized = true
+	return nil
+}
+
+// Discard returns an initialized logger that discards everything logged with it. It's the
+// recommended default of optional *Logger parameters, for example of libraries, so that they
+// can log unconditionally: its methods do nothing and are cheap, as no level is logged, and
+// stopping it does nothing too. It can't be initialized again.
+func Discard() *Logger {
+	return &Logger{initialized: true, discard: true}
+}
+
+// init checks the parameters and opens the destinations before replacing the state of the
+// logger, which it does at once under the lock, as the logger may be used concurrently
+func (log *Logger) init(parameters Parameters) error {
+	format := strings.ToLower(parameters.Format)
+	switch format {
+	case "":
+		format = TextFormat
+	case TextFormat, JSONFormat, TextJSONFormat:
+	default:
+		return &Error{fmt.Sprintf("Invalid log/trace format: %s\n", parameters.Format)}
+	}
 
+	var compressionLevel int
+	switch {
+	case parameters.CompressionLevel == 0:
+		compressionLevel = gzip.DefaultCompression
+	case parameters.CompressionLevel == NoCompression:
+		compressionLevel = gzip.NoCompression
+	case parameters.CompressionLevel >= gzip.BestSpeed && parameters.CompressionLevel <= gzip.BestCompression:
+		compressionLevel = parameters.CompressionLevel
+	default:
+		return &Error{fmt.Sprintf("Invalid compression level: %d\n", parameters.CompressionLevel)}
+	}
+
+	maxFileSize := int64(parameters.MaxFileSize) * 1024
+	if parameters.MaxFileSizeStr != "" {
+		size, err := ParseSize(parameters.MaxFileSizeStr)
+		if err != nil {
+			return err
+		}
+		maxFileSize = size
+	}
+
+	var compressionFormat, archiveExtension string
+	switch format := strings.ToLower(parameters.CompressionFormat); format {
+	case "", GzipCompression:
+		compressionFormat = GzipCompression
+		archiveExtension = ".gz"
+	case ZstdCompression:
+		compressionFormat = ZstdCompression
+		archiveExtension = ".zst"
+	case NoneCompression:
+		compressionFormat = NoneCompression
+		archiveExtension = ""
+	default:
+		return &Error{fmt.Sprintf("Invalid compression format: %s\n", parameters.CompressionFormat)}
+	}
+
+	if err := checkArchivePattern(parameters.ArchivePattern); err != nil {
+		return err
+	}
+
+	timeFormat := textTimeFormat
+	if parameters.TimeFormat != "" {
+		timeFormat = parameters.TimeFormat
+	}
+
+	if err := checkDestinationsList(parameters.Destinations); err != nil {
+		return err
+	}
+	if parameters.RequiredDestinations != "" {
+		if err := checkDestinationsList(parameters.RequiredDestinations); err != nil {
+			return err
+		}
+	}
 	destinations, entries := log.ParseDestinationsList(parameters.Destinations)
 	if !entries {
 		destinations[FILE] = true
 	}
 
-	writers := make([]io.Writer, 0)
+	fileMode := parameters.FileMode
+	if fileMode == 0 {
+		fileMode = defaultFileMode
+	}
+	fileFlags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if parameters.SyncWrites {
+		fileFlags |= os.O_SYNC
+	}
+	var currentFile *os.File
 	if destinations[FILE] {
+		dirMode := parameters.DirMode
+		if dirMode == 0 {
+			dirMode = defaultDirMode
+		}
 		info, err := os.Stat(parameters.RootPath)
 		if os.IsNotExist(err) {
-			err = os.MkdirAll(parameters.RootPath, 0755)
+			err = os.MkdirAll(parameters.RootPath, dirMode)
 			if err != nil {
 				return &Error{fmt.Sprintf("Failed to open log file at %s. Error: %s\n", parameters.RootPath, err)}
 			}
@@ -113,242 +404,983 @@ func (log *Logger) Init(parameters Parameters) error {
 			}
 		}
 
-		f, err := os.OpenFile(parameters.RootPath+"/"+parameters.FileName+".log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		currentFile, err = os.OpenFile(parameters.RootPath+"/"+parameters.FileName+".log", fileFlags, fileMode)
 		if err != nil {
 			return &Error{fmt.Sprintf("Failed to open log file at %s. Error: %s\n", parameters.RootPath, err)}
 		}
-		writers = append(writers, f)
-		log.CurrentFile = f
 	}
-	if destinations[STDOUT] {
-		writers = append(writers, os.Stdout)
-		log.Stdout = true
+	var stdoutColor *colorWriter
+	if destinations[STDOUT] && parameters.Colorize && isTerminal(os.Stdout) {
+		stdoutColor = &colorWriter{writer: os.Stdout, level: -1}
 	}
+	var warnings []string
+	var syslogWriter io.Writer
 	if destinations[SYSLOG] {
-		slWriter, err := syslog.New(syslog.LOG_NOTICE, parameters.FileName)
+		var slWriter io.Writer
+		var err error
+		address := parameters.SyslogAddress
+		if remoteAddress := syslogAddress(parameters.Destinations); remoteAddress != "" {
+			address = remoteAddress
+		}
+		if address != "" {
+			var remote *remoteSyslog
+			if remote, err = newRemoteSyslog(address, parameters.SyslogFormat, parameters.FileName,
+				parameters.SyslogBufferLines); err != nil {
+				if currentFile != nil {
+					currentFile.Close()
+				}
+				return &Error{fmt.Sprintf("Failed to create syslog writer. Error: %s\n", err)}
+			}
+			if parameters.VerifyDestinations {
+				err = remote.connect()
+			}
+			slWriter = remote
+		} else {
+			var local *syslog.Writer
+			local, err = syslog.New(syslog.LOG_NOTICE, parameters.FileName)
+			if err == nil && parameters.VerifyDestinations {
+				if err = local.Debug(parameters.FileName + " log destination check"); err != nil {
+					local.Close()
+				}
+			}
+			slWriter = local
+		}
 		if err != nil {
-			return &Error{fmt.Sprintf("Failed to create syslog writer. Error: %s\n", err)}
+			if remote, ok := slWriter.(*remoteSyslog); ok {
+				remote.Close()
+			}
+			required, _ := log.ParseDestinationsList(parameters.RequiredDestinations)
+			if !parameters.VerifyDestinations || required[SYSLOG] {
+				if currentFile != nil {
+					currentFile.Close()
+				}
+				return &Error{fmt.Sprintf("Failed to create syslog writer. Error: %s\n", err)}
+			}
+			warnings = append(warnings, fmt.Sprintf("The syslog destination is unreachable and will not be used. Error: %s\n", err))
+		} else {
+			syslogWriter = slWriter
 		}
-		writers = append(writers, slWriter)
-		log.Syslog = slWriter
 	}
-	if len(writers) == 0 && !destinations[GLOG] {
+	useLogger := currentFile != nil || destinations[STDOUT] || syslogWriter != nil
+	if !useLogger && !destinations[GLOG] {
 		return &Error{fmt.Sprintf("Invalid log/trace destinations list: %s\n", parameters.Destinations)}
 	}
 
-	if len(writers) > 0 {
-		mw := io.MultiWriter(writers...)
-		if log.Tracing {
-			parameters.Prefix = "* " + parameters.Prefix
-		}
-		log.Logger = golog.New(mw, parameters.Prefix, golog.LstdFlags)
-		log.useLogger = true
-		log.Level = logLevel(parameters.Level)
-		log.MaxFileSize = int64(parameters.MaxFileSize) * 1024
-		log.MaxCompressedFilesNumber = parameters.MaxCompressedFilesNumber
-
-		log.lockChannel = make(chan int, 1)
-		log.lockChannel <- 1
-
-		if log.CurrentFile != nil {
-			log.ticker = time.NewTicker(time.Second * time.Duration(parameters.MaintenanceInterval))
-			go func() {
-				for {
-					select {
-					case <-log.ticker.C:
-						log.checkFiles()
-					}
+	prefix := parameters.Prefix
+	if useLogger && log.Tracing {
+		prefix = "* " + prefix
+	}
+	level := logLevel(parameters.Level)
+	countersLevel := STATUS
+	if parameters.CountersLevel != "" {
+		countersLevel = logLevel(parameters.CountersLevel)
+	}
+
+	log.lock()
+	log.resetDestinations()
+	log.format = format
+	log.compressionLevel = compressionLevel
+	log.compressionFormat = compressionFormat
+	log.archiveExtension = archiveExtension
+	log.archivePattern = parameters.ArchivePattern
+	log.archiveDated = strings.Contains(parameters.ArchivePattern, ArchiveDateToken)
+	log.prefixAsField = parameters.PrefixAsField
+	log.includeCaller = parameters.IncludeCaller
+	log.shortLevels = parameters.ShortLevels
+	log.component = strings.TrimSpace(parameters.Prefix)
+	log.timeFormat = timeFormat
+	log.utc = parameters.UTC
+	log.flushErrors = parameters.FlushErrors
+	log.stdoutFallback = parameters.StdoutFallback
+	log.fileMode = fileMode
+	log.fileFlags = fileFlags
+	log.fileName = ""
+	if currentFile != nil {
+		log.CurrentFile = currentFile
+		log.fileName = currentFile.Name()
+		log.fileOpenTime = time.Now()
+		if parameters.BufferSize > 0 && !parameters.SyncWrites {
+			log.buffer = bufio.NewWriterSize(currentFile, parameters.BufferSize)
+		}
+	}
+	log.Stdout = destinations[STDOUT]
+	log.colorWriter = stdoutColor
+	log.Syslog = syslogWriter
+	log.prefix = prefix
+	log.level.Store(int32(level))
+	log.countersLevel = countersLevel
+	log.MaxFileSize = maxFileSize
+	log.MaxCompressedFilesNumber = parameters.MaxCompressedFilesNumber
+	log.maxFileAge = time.Duration(parameters.MaxFileAgeHours) * time.Hour
+	log.maxArchiveAge = time.Duration(parameters.MaxCompressedFileAgeDays) * 24 * time.Hour
+	log.maxTotalBytes = parameters.MaxTotalLogBytes
+	if useLogger {
+		// Syslog writers are written to separately, with the priority of each line's level.
+		// The prefix and the timestamp are added by formatEntry.
+		log.Logger = golog.New(log.destinationsWriter(), "", 0)
+		log.useLogger.Store(true)
+	}
+	log.glog.Store(destinations[GLOG])
+	log.unLock()
+
+	interval := parameters.MaintenanceInterval
+	if interval <= 0 {
+		if currentFile != nil {
+			warnings = append(warnings, fmt.Sprintf("Invalid maintenance interval %d, using %d seconds instead\n",
+				interval, defaultMaintenanceInterval))
+		}
+		interval = defaultMaintenanceInterval
+	}
+	if log.done == nil {
+		log.done = make(chan struct{})
+	}
+	log.maintenance.Add(1)
+	if currentFile == nil {
+		// Without a log file, the maintenance is logging the summaries of the rate limited messages
+		go log.summarize(time.Second*time.Duration(interval), log.done)
+	} else {
+		log.recoverRotation()
+		log.ticker = time.NewTicker(time.Second * time.Duration(interval))
+		go func(ticker *time.Ticker, done chan struct{}) {
+			defer log.maintenance.Done()
+			for {
+				select {
+				case <-ticker.C:
+					log.maintain()
+				case <-done:
+					return
 				}
-			}()
+			}
+		}(log.ticker, log.done)
+	}
+
+	if parameters.HeartbeatInterval > 0 {
+		if log.done == nil {
+			log.done = make(chan struct{})
 		}
+		go log.heartbeat(time.Second*time.Duration(parameters.HeartbeatInterval), log.done)
 	}
 
-	if destinations[GLOG] {
-		log.Level = logLevel(parameters.Level)
-		log.prefix = parameters.Prefix
-		log.glog = true
+	for _, warning := range warnings {
+		log.Warning("%s", warning)
 	}
 	return nil
 }
 
+// InitWithWriter initializes the logger to write in the text format to w, at the level,
+// without a log file, syslog or glog. It's meant for tests, which can capture the output
+// in a bytes.Buffer. The level filtering, the formatting and the locking are the same as
+// for a logger initialized with Init.
+func (log *Logger) InitWithWriter(w io.Writer, level int) error {
+	if w == nil {
+		return &Error{"InitWithWriter was called with a nil writer\n"}
+	}
+	log.stateMutex.Lock()
+	defer log.stateMutex.Unlock()
+	if log.initialized {
+		return &Error{"The logger is already initialized\n"}
+	}
+	log.initialized = true
+
+	log.lock()
+	defer log.unLock()
+	log.resetDestinations()
+	log.format = TextFormat
+	log.timeFormat = textTimeFormat
+	log.countersLevel = STATUS
+	if log.Tracing {
+		log.prefix = "* "
+	}
+	// The prefix and the timestamp are added by formatEntry
+	log.Logger = golog.New(w, "", 0)
+	log.useLogger.Store(true)
+	log.level.Store(int32(level))
+	return nil
+}
+
+// maintain is the maintenance check run at each maintenance interval: it logs the summaries
+// of the rate limited messages, leaves the degraded mode, flushes the buffer and rotates the
+// log file if needed
+func (log *Logger) maintain() {
+	log.logRateLimitSummaries(false)
+	log.maintenanceMutex.Lock()
+	defer log.maintenanceMutex.Unlock()
+	log.lock()
+	log.retryFile()
+	log.flushBuffer()
+	log.unLock()
+	log.checkFiles()
+}
+
+// resetDestinations forgets the destinations, so that a logger initialized again after Stop
+// doesn't write to those of the previous Init. Must be called with the logger locked, or
+// before it's used.
+func (log *Logger) resetDestinations() {
+	log.CurrentFile = nil
+	log.Stdout = false
+	log.Syslog = nil
+	log.useLogger.Store(false)
+	log.glog.Store(false)
+	log.colorWriter = nil
+	log.buffer = nil
+	log.degraded = false
+	log.writeFailures = 0
+}
+
+// heartbeat logs a STATUS line with the process start time and uptime at each interval,
+// until done is closed
+func (log *Logger) heartbeat(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fields := map[string]interface{}{
+				"start":  processStart.Format(time.RFC3339),
+				"uptime": time.Since(processStart).Round(time.Second).String(),
+			}
+			log.printf(STATUS, fields, "heartbeat")
+		case <-done:
+			return
+		}
+	}
+}
+
 // ParseDestinationsList parses a list of destinations
 func (log *Logger) ParseDestinationsList(destinations string) ([]bool, bool) {
 	result := make([]bool, destinationTypes, destinationTypes)
 	dests := strings.Split(destinations, ",")
 
 	for _, dest := range dests {
-		if strings.EqualFold(dest, "file") {
-			result[FILE] = true
-		} else if strings.EqualFold(dest, "stdout") {
-			result[STDOUT] = true
-		} else if strings.EqualFold(dest, "syslog") {
-			result[SYSLOG] = true
-		} else if strings.EqualFold(dest, "glog") {
-			result[GLOG] = true
+		if destination, ok := parseDestination(dest); ok {
+			result[destination] = true
 		}
 	}
 
 	return result, len(dests) != 0
 }
 
-func (log *Logger) getOldestZipFileNumber() int {
-	if log.CurrentFile == nil {
-		return 0
+// parseDestination returns the type of a destination of a destinations list, ignoring the
+// surrounding whitespace, and false if it isn't a valid destination
+func parseDestination(dest string) (int, bool) {
+	dest = strings.TrimSpace(dest)
+	if strings.EqualFold(dest, "file") {
+		return FILE, true
+	} else if strings.EqualFold(dest, "stdout") {
+		return STDOUT, true
+	} else if strings.EqualFold(dest, "syslog") || isSyslogURL(dest) {
+		return SYSLOG, true
+	} else if strings.EqualFold(dest, "glog") {
+		return GLOG, true
+	}
+	return 0, false
+}
+
+// checkDestinationsList returns an error naming the first invalid destination of a list
+func checkDestinationsList(destinations string) error {
+	for _, dest := range strings.Split(destinations, ",") {
+		if _, ok := parseDestination(dest); !ok {
+			return &Error{fmt.Sprintf("Invalid log/trace destination '%s' in the destinations list: %s\n",
+				strings.TrimSpace(dest), destinations)}
+		}
+	}
+	return nil
+}
+
+// isSyslogURL returns true if the destination is a remote syslog server, syslog://host:port
+func isSyslogURL(dest string) bool {
+	return len(dest) > len("syslog://") && strings.EqualFold(dest[:len("syslog://")], "syslog://")
+}
+
+// syslogAddress returns the first remote syslog server in a list of destinations, if any
+func syslogAddress(destinations string) string {
+	for _, dest := range strings.Split(destinations, ",") {
+		if dest = strings.TrimSpace(dest); isSyslogURL(dest) {
+			return dest
+		}
 	}
-	for i := 1; ; i++ {
-		fileName := fmt.Sprintf("%s.%d.gz", log.CurrentFile.Name(), i)
-		if _, err := os.Stat(fileName); os.IsNotExist(err) {
-			return i - 1
+	return ""
+}
+
+// pruneArchives deletes the oldest of the rotated log files, listed from the newest to the
+// oldest, either those older than the age limit, or if there is no age limit those beyond the
+// count limit (leaving room for one more archive). Returns the rotated log files left.
+func (log *Logger) pruneArchives(archives []string) []string {
+	for ; len(archives) > 0; archives = archives[:len(archives)-1] {
+		fileName := archives[len(archives)-1]
+		if log.maxArchiveAge > 0 {
+			info, err := os.Stat(fileName)
+			if err != nil || time.Since(info.ModTime()) <= log.maxArchiveAge {
+				break
+			}
+		} else if len(archives) < log.MaxCompressedFilesNumber {
+			break
+		}
+		if err := os.Remove(fileName); err != nil {
+			log.reportError("Failed to remove compressed log file. Error: %s\n", err)
 		}
 	}
+	return archives
 }
 
+// checkFiles rotates the log file when it's too big or too old, and prunes the rotated log
+// files. Must be called with the maintenanceMutex locked, so that the log file isn't reopened
+// or replaced meanwhile.
 func (log *Logger) checkFiles() {
-	if log.CurrentFile == nil {
+	log.lock()
+	savFile := log.CurrentFile
+	log.unLock()
+	if savFile == nil {
 		return
 	}
-	fi, err := log.CurrentFile.Stat()
+	// The archives are renamed and pruned only once the previous rotated log file is
+	// compressed, so that their numbering stays consistent: until then the maintenance is
+	// postponed to the next check
+	if log.compressing != nil {
+		select {
+		case <-log.compressing:
+			log.compressing = nil
+		default:
+			return
+		}
+	}
+	fi, err := savFile.Stat()
 	if err != nil {
-		fmt.Printf("Failed to get log file information. Error: %s\n", err)
+		log.reportError("Failed to get log file information. Error: %s\n", err)
 		return
 	}
+	if log.maxTotalBytes > 0 {
+		// Only now that no rotated log file is being compressed, which would be missed, and
+		// whose archive could be removed while it's written
+		log.enforceTotalSize()
+	}
 
-	if fi.Size() > log.MaxFileSize {
-		compressedFiles := log.getOldestZipFileNumber()
+	// Rotate when the file is too big, or when it is too old (as long as it isn't empty)
+	tooOld := log.maxFileAge > 0 && fi.Size() > 0 && time.Since(log.fileOpenTime) > log.maxFileAge
+	if fi.Size() <= log.MaxFileSize && !tooOld {
+		if log.maxArchiveAge > 0 {
+			log.pruneArchives(log.archives())
+		}
+		return
+	}
 
-		if compressedFiles >= log.MaxCompressedFilesNumber {
-			for i := compressedFiles; i > log.MaxCompressedFilesNumber-1; i-- {
-				fileName := fmt.Sprintf("%s.%d.gz", log.CurrentFile.Name(), i)
-				if err := os.Remove(fileName); err != nil {
-					fmt.Printf("Failed to remove compressed log file. Error: %s\n", err)
+	compressedFiles := len(log.pruneArchives(log.archives()))
+	for i := compressedFiles; i > 0 && !log.archiveDated; i-- {
+		fileName := log.archiveName(i)
+		newFileName := log.archiveName(i + 1)
+		if err = rename(fileName, newFileName); err != nil {
+			log.reportError("Failed to rename compressed log file %s. Error: %s\n", fileName, err)
+			// The archives must stay numbered without gaps, otherwise the older ones are lost:
+			// move back those already renamed, and retry the rotation at the next check
+			for j := i + 1; j <= compressedFiles; j++ {
+				if err = rename(log.archiveName(j+1), log.archiveName(j)); err != nil {
+					log.reportError("Failed to restore compressed log file %s. Error: %s\n", log.archiveName(j), err)
+					break
 				}
-				compressedFiles--
-			}
-		}
-		for i := compressedFiles; i > 0; i-- {
-			fileName := fmt.Sprintf("%s.%d.gz", log.CurrentFile.Name(), i)
-			newFileName := fmt.Sprintf("%s.%d.gz", log.CurrentFile.Name(), i+1)
-			if os.Rename(fileName, newFileName); err != nil {
-				fmt.Printf("Failed to rename compressed log file. Error: %s\n", err)
 			}
+			return
 		}
+	}
 
-		savFile := log.CurrentFile
-		curFileName := log.CurrentFile.Name()
-		savFileName := log.CurrentFile.Name() + ".1"
-		zipFileName := log.CurrentFile.Name() + ".1.gz"
+	curFileName := log.fileName
+	savFileName := log.fileName + ".1"
+	zipFileName := log.archiveName(1)
+	if log.archiveDated {
+		zipFileName = log.nextDatedArchiveName(time.Now())
+	} else if log.compressionFormat == NoneCompression {
+		// The log file is rotated to the archive itself, there is nothing to compress
+		savFileName = zipFileName
+	}
 
-		log.lock()
-		if err := savFile.Close(); err != nil {
-			fmt.Printf("Failed to close the log file. Error: %s\n", err)
-			return
+	// A rotated log file left by a failed compression, typically because the file system was
+	// full, is compressed first rather than overwritten, and the rotation is postponed
+	if _, err := os.Stat(savFileName); err == nil {
+		log.startCompression(savFileName, zipFileName)
+		return
+	}
+
+	// Whatever fails, the logger must be left writing to an open file, or to no file (or to
+	// stdout with StdoutFallback) if none can be opened, rather than to the closed file. The
+	// errors are reported once unlocked, since they may be logged.
+	var failures []error
+	log.lock()
+	if log.CurrentFile != savFile {
+		// Stopped meanwhile, by StopWithTimeout
+		log.unLock()
+		return
+	}
+	log.flushBuffer()
+	if err := savFile.Close(); err != nil {
+		failures = append(failures, &Error{fmt.Sprintf("Failed to close the log file. Error: %s\n", err)})
+	}
+	rotated := true
+	if err = rename(curFileName, savFileName); err != nil {
+		failures = append(failures, &Error{fmt.Sprintf("Failed to rename the log file. Error: %s\n", err)})
+		rotated = false
+	}
+
+	newFile, err := os.OpenFile(curFileName, log.fileFlags, log.fileMode)
+	if err != nil {
+		failures = append(failures, &Error{fmt.Sprintf("Failed to open log file %s. Error: %s\n", curFileName, err)})
+		fallbackFileName := curFileName
+		if rotated {
+			fallbackFileName = savFileName
 		}
-		if err = os.Rename(curFileName, savFileName); err != nil {
-			fmt.Printf("Failed to rename the log file. Error: %s\n", err)
+		rotated = false
+		if newFile, err = os.OpenFile(fallbackFileName, log.fileFlags, log.fileMode); err != nil {
+			failures = append(failures, &Error{fmt.Sprintf("Failed to reopen log file %s. Error: %s\n", fallbackFileName, err)})
+			newFile = nil
 		}
+	}
+	log.CurrentFile = newFile
+	if newFile == nil && log.stdoutFallback && !log.Stdout && log.Syslog == nil {
+		log.Stdout = true
+	}
+	log.fileOpenTime = time.Now()
+	log.Logger.SetOutput(log.destinationsWriter())
+	log.unLock()
+	for _, failure := range failures {
+		log.internalError(failure)
+	}
+	if !rotated || savFileName == zipFileName {
+		return
+	}
 
-		log.CurrentFile, err = os.OpenFile(curFileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
-		if err != nil {
-			fmt.Printf("Failed to open log file %s. Error: %s\n", curFileName, err)
-			log.unLock()
-			return
+	log.startCompression(savFileName, zipFileName)
+}
+
+// startCompression compresses the rotated log file in the background, so that the maintenance
+// checks aren't delayed by large files
+func (log *Logger) startCompression(source string, destination string) {
+	done := make(chan struct{})
+	log.compressing = done
+	log.compressions.Add(1)
+	go func() {
+		defer log.compressions.Done()
+		defer close(done)
+		log.compressFile(source, destination)
+	}()
+}
+
+// compressFile compresses the rotated log file source to destination in a single pass, and
+// then removes source. If destination exists the compressed log is appended to it, as both
+// gzip and zstd streams can be concatenated. If compressing fails, destination is restored
+// (or removed) and source is left in place, so that no log is lost.
+func (log *Logger) compressFile(source string, destination string) {
+	in, err := os.Open(source)
+	if err != nil {
+		log.reportError("Failed to open log file %s. Error: %s\n", source, err)
+		return
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_APPEND, log.fileMode)
+	if err != nil {
+		log.reportError("Failed to open file to compress log. Error: %s\n", err)
+		return
+	}
+	var previousSize int64
+	if info, err := out.Stat(); err == nil {
+		previousSize = info.Size()
+	}
+	w, err := log.newCompressor(out)
+	if err == nil {
+		if _, err = io.Copy(w, in); err != nil {
+			log.reportError("Failed to copy log to %s. Error: %s\n", log.compressionFormat, err)
+		} else if err = w.Close(); err != nil {
+			log.reportError("Failed to close file the compressed log. Error: %s\n", err)
 		}
-		writers := make([]io.Writer, 0)
-		writers = append(writers, log.CurrentFile)
-		if log.Stdout {
-			writers = append(writers, os.Stdout)
+	} else {
+		log.reportError("Failed to create the %s writer. Error: %s\n", log.compressionFormat, err)
+	}
+	if closeErr := out.Close(); err == nil && closeErr != nil {
+		log.reportError("Failed to close file the compressed log. Error: %s\n", closeErr)
+		err = closeErr
+	}
+	if err != nil {
+		if previousSize > 0 {
+			os.Truncate(destination, previousSize)
+		} else {
+			os.Remove(destination)
 		}
-		if log.Syslog != nil {
-			writers = append(writers, log.Syslog)
+		return
+	}
+
+	in.Close()
+	if err = os.Remove(source); err != nil {
+		log.reportError("Failed to remove the log file. Error: %s\n", err)
+	}
+}
+
+// destinationsWriter returns a writer to the current log file, stdout and syslog destinations.
+// When buffering, the buffer is switched to the current log file, so it must have been
+// flushed to the previous one. The degraded mode is left, so that the new log file is tried.
+func (log *Logger) destinationsWriter() io.Writer {
+	log.degraded = false
+	log.writeFailures = 0
+	writers := make([]io.Writer, 0)
+	if log.CurrentFile != nil {
+		if log.buffer != nil {
+			log.buffer.Reset(log.CurrentFile)
 		}
-		log.Logger.SetOutput(io.MultiWriter(writers...))
-		log.unLock()
+		writers = append(writers, fileWriter{log})
+	}
+	if log.Stdout {
+		writers = append(writers, log.stdoutWriter())
+	}
+	if log.Syslog != nil && !isLeveledSyslog(log.Syslog) {
+		writers = append(writers, log.Syslog)
+	}
+	return io.MultiWriter(writers...)
+}
 
-		savFile, err = os.Open(savFileName)
-		if err != nil {
-			fmt.Printf("Failed to open log file %s. Error: %s\n", curFileName, err)
-			return
+// Reopen closes and reopens the log file, for compatibility with external log rotation such as
+// logrotate, which renames the log file and then signals the process (usually with SIGHUP).
+// Reopen is a no-op if the logger doesn't write to a log file. The categories' log files are
+// reopened too. Reopen waits for the maintenance check in progress, if any, so it must not be
+// called by the OnInternalError handler.
+func (log *Logger) Reopen() error {
+	err := log.reopen()
+	log.eachCategory(func(category *Logger) {
+		if categoryErr := category.Reopen(); err == nil {
+			err = categoryErr
 		}
+	})
+	return err
+}
 
-		var zipFile *os.File
-		zipFile, err = os.Create(zipFileName)
-		if err != nil {
-			fmt.Printf("Failed to open file to compress log. Error: %s\n", err)
-			return
+func (log *Logger) reopen() error {
+	// Not while the maintenance rotates the log file
+	log.maintenanceMutex.Lock()
+	defer log.maintenanceMutex.Unlock()
+	log.lock()
+	defer log.unLock()
+	if !log.useLogger.Load() || log.CurrentFile == nil {
+		return nil
+	}
+
+	fileName := log.CurrentFile.Name()
+	f, err := os.OpenFile(fileName, log.fileFlags, log.fileMode)
+	if err != nil {
+		return &Error{fmt.Sprintf("Failed to reopen log file %s. Error: %s\n", fileName, err)}
+	}
+	log.flushBuffer()
+	oldFile := log.CurrentFile
+	log.CurrentFile = f
+	log.fileOpenTime = time.Now()
+	log.Logger.SetOutput(log.destinationsWriter())
+	oldFile.Close()
+	return nil
+}
+
+// enforceTotalSize deletes the oldest rotated log files until the total size of the log
+// file and of the rotated log files is within MaxTotalLogBytes. A rotated log file left
+// uncompressed by a failed compression counts, but isn't deleted. Must not be called while
+// a rotated log file is being compressed.
+func (log *Logger) enforceTotalSize() {
+	var total int64
+	if info, err := os.Stat(log.fileName); err == nil {
+		total = info.Size()
+	}
+	if log.compressionFormat != NoneCompression || log.archiveDated {
+		if info, err := os.Stat(log.fileName + ".1"); err == nil {
+			total += info.Size()
+		}
+	}
+	archives := log.archives()
+	sizes := make([]int64, len(archives))
+	for i, archive := range archives {
+		if info, err := os.Stat(archive); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
 		}
-		defer zipFile.Close()
+	}
 
-		w := gzip.NewWriter(zipFile)
-		if _, err = io.Copy(w, savFile); err != nil {
-			fmt.Printf("Failed to copy log to gzip. Error: %s\n", err)
-			return
+	removed := 0
+	for i := len(archives) - 1; i >= 0 && total > log.maxTotalBytes; i-- {
+		if err := os.Remove(archives[i]); err != nil {
+			log.reportError("Failed to remove compressed log file. Error: %s\n", err)
+			break
 		}
-		if err = w.Close(); err != nil {
-			fmt.Printf("Failed to close file the compressed log. Error: %s\n", err)
-			return
+		total -= sizes[i]
+		removed++
+	}
+	if removed > 0 {
+		log.Status("Removed %d rotated log files to keep the logs within %d bytes\n", removed, log.maxTotalBytes)
+	}
+}
+
+// SetWriters atomically replaces the set of writers the logger writes to. The CurrentFile,
+// Stdout and Syslog bookkeeping is updated from the new writers: the first *os.File other
+// than os.Stdout becomes CurrentFile, and a *syslog.Writer becomes Syslog, which is written
+// to with the syslog priority of each line's level.
+// The previous writers are not closed, that is left to the caller. Size based rotation
+// of a new CurrentFile only occurs if the logger was initialized with a log file.
+// Passing no writers is an error, as it would silently mute the logger. SetWriters waits for
+// the maintenance check in progress, if any, so it must not be called by the OnInternalError
+// handler.
+func (log *Logger) SetWriters(writers ...io.Writer) error {
+	if len(writers) == 0 {
+		return &Error{"SetWriters was called with no writers\n"}
+	}
+
+	// Not while the maintenance rotates the log file
+	log.maintenanceMutex.Lock()
+	defer log.maintenanceMutex.Unlock()
+	log.lock()
+	defer log.unLock()
+	if !log.useLogger.Load() {
+		return &Error{"SetWriters was called on a logger that doesn't use writers\n"}
+	}
+
+	var currentFile *os.File
+	var syslogWriter io.Writer
+	stdout := false
+	fileIndex := -1
+	outputs := make([]io.Writer, 0, len(writers))
+	for _, w := range writers {
+		switch writer := w.(type) {
+		case *syslog.Writer, *remoteSyslog:
+			// Written to separately, with the priority of each line's level
+			syslogWriter = writer
+			continue
+		case *os.File:
+			if writer == os.Stdout {
+				stdout = true
+				w = log.stdoutWriter()
+			} else if currentFile == nil {
+				currentFile = writer
+				fileIndex = len(outputs)
+			}
 		}
-		if err = savFile.Close(); err != nil {
-			fmt.Printf("Failed to close the log file. Error: %s\n", err)
-			return
+		outputs = append(outputs, w)
+	}
+
+	log.flushBuffer()
+	if log.buffer != nil && currentFile != nil {
+		log.buffer.Reset(currentFile)
+	}
+	if currentFile != nil {
+		outputs[fileIndex] = fileWriter{log}
+	}
+	log.degraded = false
+	log.writeFailures = 0
+	log.Logger.SetOutput(io.MultiWriter(outputs...))
+	log.CurrentFile = currentFile
+	if currentFile != nil {
+		log.fileName = currentFile.Name()
+	}
+	log.Stdout = stdout
+	log.Syslog = syslogWriter
+	return nil
+}
+
+// flushBuffer writes the buffered lines, if any, to the log file. Must be called with the
+// logger locked.
+func (log *Logger) flushBuffer() {
+	if log.buffer != nil && !log.degraded && log.CurrentFile != nil {
+		if err := log.buffer.Flush(); err != nil {
+			log.fileWriteFailed(err)
 		}
-		if err = os.Remove(savFileName); err != nil {
-			fmt.Printf("Failed to remove the log file. Error: %s\n", err)
-			return
+	}
+}
+
+// Flush writes the buffered lines, commits the log file to stable storage and flushes glog,
+// so that nothing is lost if the process exits right after logging
+func (log *Logger) Flush() {
+	log.eachCategory((*Logger).Flush)
+	log.lock()
+	log.flushBuffer()
+	if nil != log.CurrentFile {
+		log.CurrentFile.Sync()
+	}
+	useGlog := log.glog.Load()
+	log.unLock()
+	if useGlog {
+		glog.Flush()
+	}
+}
+
+// Sync waits until the maintenance check in progress, if any, is done and the rotated log
+// files are compressed, for example before copying the log directory. Unlike Flush it doesn't
+// write the buffered lines. Sync must not be called by the OnInternalError handler, which is
+// called during the maintenance checks.
+func (log *Logger) Sync() {
+	// Compressions are only started by the maintenance checks, which can't start while locked
+	log.maintenanceMutex.Lock()
+	log.compressions.Wait()
+	log.maintenanceMutex.Unlock()
+	log.eachCategory((*Logger).Sync)
+}
+
+// nopCloser is the writer of NoneCompression, which copies the rotated log file as it is
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// newCompressor returns a writer compressing to w in the configured format and level
+func (log *Logger) newCompressor(w io.Writer) (io.WriteCloser, error) {
+	if log.compressionFormat == NoneCompression {
+		return nopCloser{w}, nil
+	}
+	if log.compressionFormat == ZstdCompression {
+		level := zstd.SpeedDefault
+		switch log.compressionLevel {
+		case gzip.DefaultCompression:
+		case gzip.NoCompression:
+			level = zstd.SpeedFastest
+		default:
+			level = zstd.EncoderLevelFromZstd(log.compressionLevel)
 		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
 	}
+	return gzip.NewWriterLevel(w, log.compressionLevel)
 }
 
-// Stop Logger
+// Stop Logger. Stopping a logger that isn't initialized, or was already stopped, does nothing.
+// Stop returns once the maintenance is stopped, the pending compression of a rotated log
+// file, if any, is complete and the hooks were called with the entries logged so far. The
+// categories are stopped and removed. Entries logged after Stop are dropped.
 func (log *Logger) Stop() {
-	if log.useLogger {
-		if nil != log.CurrentFile {
-			log.CurrentFile.Close()
-			log.ticker.Stop()
+	log.stop(time.Time{})
+}
+
+// StopWithTimeout stops the logger like Stop, but waits at most for the timeout for the
+// maintenance, the compressions and the hooks to complete, for example to shut down within
+// a grace period even if compressing is stuck on a slow disk. The log file is closed either
+// way. If the timeout expired an error is returned, and the pending work goes on in the
+// background: the logger must not be initialized again.
+func (log *Logger) StopWithTimeout(timeout time.Duration) error {
+	return log.stop(time.Now().Add(timeout))
+}
+
+// stop stops the logger, waiting for the pending work until the deadline, or as long as it
+// takes if the deadline is zero
+func (log *Logger) stop(deadline time.Time) error {
+	log.stateMutex.Lock()
+	defer log.stateMutex.Unlock()
+	if !log.initialized || log.discard {
+		return nil
+	}
+	log.initialized = false
+
+	var err error
+	log.eachCategory(func(category *Logger) {
+		if categoryErr := category.stop(deadline); err == nil {
+			err = categoryErr
+		}
+	})
+	log.categoryMutex.Lock()
+	log.categories = nil
+	log.categoryMutex.Unlock()
+
+	if nil != log.ticker {
+		log.ticker.Stop()
+		log.ticker = nil
+	}
+	if nil != log.done {
+		close(log.done)
+		log.done = nil
+	}
+	pending := make(chan struct{})
+	go func() {
+		log.maintenance.Wait()
+		log.compressions.Wait()
+		log.compressing = nil
+		log.stopHooks()
+		close(pending)
+	}()
+	if deadline.IsZero() {
+		<-pending
+	} else {
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-pending:
+		case <-timer.C:
+			if err == nil {
+				err = &Error{"Timed out waiting for the log maintenance, compressions and hooks to complete\n"}
+			}
 		}
+		timer.Stop()
+	}
+
+	log.logRateLimitSummaries(true)
+
+	// The destinations are forgotten, so that logging after Stop (or after Init again) doesn't
+	// write to the closed log file or syslog writer
+	log.lock()
+	log.flushBuffer()
+	currentFile, syslogWriter, useGlog := log.CurrentFile, log.Syslog, log.glog.Load()
+	log.resetDestinations()
+	if log.Logger != nil {
+		log.Logger.SetOutput(ioutil.Discard)
+	}
+	log.unLock()
+	if nil != currentFile {
+		currentFile.Close()
 	}
-	if log.glog {
+	switch writer := syslogWriter.(type) {
+	case *remoteSyslog:
+		writer.Close()
+	case *syslog.Writer:
+		writer.Close()
+	}
+	if useGlog {
 		glog.Flush()
 	}
+	return err
+}
+
+// SetLevel changes the logging level at runtime. It does nothing for the Discard logger,
+// whose level is always NONE.
+func (log *Logger) SetLevel(level int) {
+	if log.discard {
+		return
+	}
+	log.level.Store(int32(level))
+}
+
+// SetLevelString changes the logging level at runtime, using the level's name
+func (log *Logger) SetLevelString(name string) error {
+	level, ok := logLevels[strings.ToUpper(name)]
+	if !ok {
+		return &Error{fmt.Sprintf("Invalid log level %s specified\n", name)}
+	}
+	log.SetLevel(level)
+	return nil
+}
+
+// GetLevel returns the current logging level, which for a category is its logger's level
+func (log *Logger) GetLevel() int {
+	if log.parent != nil {
+		return log.parent.GetLevel()
+	}
+	return int(log.level.Load())
 }
 
-// IsLogging checks if the logging level if higher or equal to the level parameter
+// IsLogging checks if the logging level if higher or equal to the level parameter.
+// Invalid levels are logged as ERROR.
 func (log *Logger) IsLogging(level int) bool {
-	return log.Level >= level || (log.glog && bool(glog.V(glog.Level(logLevel2glog[level]))))
+	if !validLevel(level) {
+		level = ERROR
+	}
+	toWriters, toGlog := log.enabled(level)
+	return toWriters || toGlog
+}
+
+// enabled returns whether an entry at the level is written to the writers and to glog. The
+// destinations and the level are atomic, since Stop forgets them while the logger may still
+// be used, so that the entries that aren't logged cost no lock.
+func (log *Logger) enabled(level int) (bool, bool) {
+	useLogger, useGlog, current := log.useLogger.Load(), log.glog.Load(), int(log.level.Load())
+	if log.parent != nil {
+		current = log.parent.GetLevel()
+	}
+	return useLogger && current >= logLevelThreshold[level],
+		useGlog && bool(glog.V(glog.Level(logLevel2glog[level])))
 }
 
-func (log *Logger) printf(level int, format string, a ...interface{}) {
-	if log.useLogger && log.Level >= level {
+// destinations returns whether the logger writes to its writers and to glog
+func (log *Logger) destinations() (bool, bool) {
+	return log.useLogger.Load(), log.glog.Load()
+}
+
+func (log *Logger) printf(level int, fields map[string]interface{}, format string, a ...interface{}) {
+	log.output(4, time.Now(), level, fields, format, a...)
+}
+
+// output writes an entry with the specified timestamp. depth is the glog call depth of
+// the caller of the public logging method. An invalid level is a programming error, that
+// must not crash the process nor lose the message, so it's logged as an ERROR.
+func (log *Logger) output(depth int, t time.Time, level int, fields map[string]interface{}, format string, a ...interface{}) {
+	if !validLevel(level) {
+		format = fmt.Sprintf("(invalid log level %d) %s", level, format)
+		level = ERROR
+	}
+	logged := false
+	toWriters, toGlog := log.enabled(level)
+	if toWriters {
+		logged = true
 		log.lock()
-		log.Logger.Printf(logLevelPrefix[level]+format, a...)
+		// Formatted locked, as Init replaces the format
+		line := log.formatEntry(t, logLevelNames[level], log.levelPrefix(level), fields, format, a...)
+		if log.colorWriter != nil {
+			log.colorWriter.level = level
+		}
+		log.Logger.Print(line)
+		if log.Syslog != nil {
+			log.writeSyslog(level, line)
+		}
+		if threshold := logLevelThreshold[level]; threshold == FATAL || (threshold == ERROR && log.flushErrors) {
+			log.flushBuffer()
+			if log.CurrentFile != nil {
+				log.CurrentFile.Sync()
+			}
+		}
 		log.unLock()
 	}
-	if log.glog && bool(glog.V(glog.Level(logLevel2glog[level]))) {
+	if toGlog {
+		logged = true
 		var b bytes.Buffer
+		log.lock()
 		b.WriteString(log.prefix)
-		b.WriteString(logLevelPrefix[level])
-		fmt.Fprintf(&b, format, a...)
+		b.WriteString(log.levelPrefix(level))
+		log.unLock()
+		b.WriteString(textMessage(fields, format, a...))
 		line := b.String()
-		switch level {
+		switch logLevelThreshold[level] {
 		case FATAL, ERROR:
-			glog.ErrorDepth(3, line)
+			glog.ErrorDepth(depth, line)
 			glog.Flush()
 		case WARNING:
-			glog.WarningDepth(3, line)
+			glog.WarningDepth(depth, line)
 		default:
-			glog.InfoDepth(3, line)
+			glog.InfoDepth(depth, line)
 		}
 	}
+	if logged {
+		log.countEntry(level)
+		log.runHooks(level, fields, format, a...)
+	}
+}
+
+// stdoutWriter returns the writer used for the stdout destination
+func (log *Logger) stdoutWriter() io.Writer {
+	if log.colorWriter != nil {
+		return log.colorWriter
+	}
+	return os.Stdout
+}
+
+// levelPrefix returns the prefix of the level in the text formats
+func (log *Logger) levelPrefix(level int) string {
+	if log.shortLevels {
+		return logLevelShortPrefix[level]
+	}
+	return logLevelPrefix[level]
 }
 
 func (log *Logger) printfAlways(format string, a ...interface{}) {
-	if log.useLogger {
+	useLogger, useGlog := log.destinations()
+	if useLogger {
 		log.lock()
-		log.Logger.Printf(format, a...)
+		line := log.formatEntry(time.Now(), "", "", nil, format, a...)
+		if log.colorWriter != nil {
+			log.colorWriter.level = -1
+		}
+		log.Logger.Print(line)
+		if log.Syslog != nil {
+			log.writeSyslog(-1, line)
+		}
 		log.unLock()
 	}
-	if log.glog {
+	if useGlog {
 		var b bytes.Buffer
+		log.lock()
 		b.WriteString(log.prefix)
+		log.unLock()
 		fmt.Fprintf(&b, format, a...)
 		line := b.String()
 		glog.InfoDepth(3, line)
@@ -356,72 +1388,310 @@ func (log *Logger) printfAlways(format string, a ...interface{}) {
 }
 
 // Status log
-func (log *Logger) Status(format string, a ...interface{}) { log.printf(STATUS, format, a...) }
+func (log *Logger) Status(format string, a ...interface{}) { log.printf(STATUS, nil, format, a...) }
 
 // Fatal log
-func (log *Logger) Fatal(format string, a ...interface{}) { log.printf(FATAL, format, a...) }
+func (log *Logger) Fatal(format string, a ...interface{}) { log.printf(FATAL, nil, format, a...) }
 
 // Error log
-func (log *Logger) Error(format string, a ...interface{}) { log.printf(ERROR, format, a...) }
+func (log *Logger) Error(format string, a ...interface{}) { log.printf(ERROR, nil, format, a...) }
 
 // Warning log
-func (log *Logger) Warning(format string, a ...interface{}) { log.printf(WARNING, format, a...) }
+func (log *Logger) Warning(format string, a ...interface{}) { log.printf(WARNING, nil, format, a...) }
 
 // Info log
-func (log *Logger) Info(format string, a ...interface{}) { log.printf(INFO, format, a...) }
+func (log *Logger) Info(format string, a ...interface{}) { log.printf(INFO, nil, format, a...) }
 
 // Debug log
-func (log *Logger) Debug(format string, a ...interface{}) { log.printf(DEBUG, format, a...) }
+func (log *Logger) Debug(format string, a ...interface{}) { log.printf(DEBUG, nil, format, a...) }
 
 // Trace log
-func (log *Logger) Trace(format string, a ...interface{}) { log.printf(TRACE, format, a...) }
+func (log *Logger) Trace(format string, a ...interface{}) { log.printf(TRACE, nil, format, a...) }
+
+// Statusln logs the message verbatim, without interpreting it as a format
+func (log *Logger) Statusln(message string) { log.println(STATUS, message) }
+
+// Fatalln logs the message verbatim, without interpreting it as a format
+func (log *Logger) Fatalln(message string) { log.println(FATAL, message) }
+
+// Errorln logs the message verbatim, without interpreting it as a format
+func (log *Logger) Errorln(message string) { log.println(ERROR, message) }
+
+// Warningln logs the message verbatim, without interpreting it as a format
+func (log *Logger) Warningln(message string) { log.println(WARNING, message) }
 
-// Dump a struct to the logger
+// Infoln logs the message verbatim, without interpreting it as a format
+func (log *Logger) Infoln(message string) { log.println(INFO, message) }
+
+// Debugln logs the message verbatim, without interpreting it as a format
+func (log *Logger) Debugln(message string) { log.println(DEBUG, message) }
+
+// Traceln logs the message verbatim, without interpreting it as a format
+func (log *Logger) Traceln(message string) { log.println(TRACE, message) }
+
+// println logs the message verbatim, followed by a newline unless it ends with one
+func (log *Logger) println(level int, message string) {
+	if strings.HasSuffix(message, "\n") {
+		log.output(4, time.Now(), level, nil, "%s", message)
+	} else {
+		log.output(4, time.Now(), level, nil, "%s\n", message)
+	}
+}
+
+// Log logs at the specified level, typically a level registered with RegisterLevel
+func (log *Logger) Log(level int, format string, a ...interface{}) {
+	log.printf(level, nil, format, a...)
+}
+
+// LogAt logs at the specified level with the timestamp t rather than the current time,
+// for example when replaying historical events. The timestamp isn't applied to glog output.
+func (log *Logger) LogAt(t time.Time, level int, format string, a ...interface{}) {
+	log.output(3, t, level, nil, format, a...)
+}
+
+// LogFunc logs the message returned by message at the specified level, calling message only if
+// the level is logged. The arguments of the level methods are evaluated even when their level
+// isn't logged, so expensive messages on hot paths should either be built by a function passed
+// to LogFunc, or be guarded with IsLogging:
+//
+//	if log.IsLogging(logger.TRACE) {
+//		log.Trace("message %s", expensiveFormat())
+//	}
+func (log *Logger) LogFunc(level int, message func() string) {
+	if log.IsLogging(level) {
+		log.output(3, time.Now(), level, nil, "%s", message())
+	}
+}
+
+// LogCounters logs a snapshot of the counters as a single entry at the level configured by
+// Parameters.CountersLevel (STATUS by default). In text format the counters are written as
+// name=value pairs sorted by name, in JSON format each counter is a field of the entry.
+func (log *Logger) LogCounters(counters map[string]int64) {
+	fields := make(map[string]interface{}, len(counters))
+	for name, value := range counters {
+		fields[name] = value
+	}
+	log.lock()
+	level := log.countersLevel
+	log.unLock()
+	log.printf(level, fields, "counters")
+}
+
+// Dump a struct, slice, array or map, or a pointer to one, to the logger at the STATUS level.
+// Nested structs, slices, arrays, maps (sorted by key) and pointers are expanded. The values
+// of fields tagged with `log:"redact"`, such as passwords, are written as ****.
 func (log *Logger) Dump(label string, a interface{}) {
-	objectType := reflect.TypeOf(a)
-	if objectType.Kind() != reflect.Struct {
-		log.printfAlways("Dump was called with an object that wasn't a struct\n")
+	log.dump(STATUS, label, a)
+}
+
+// DumpLevel dumps a value like Dump, at the specified level. Nothing is done if the level
+// isn't logged, so verbose dumps can be left in place at the DEBUG or TRACE level.
+func (log *Logger) DumpLevel(level int, label string, a interface{}) {
+	log.dump(level, label, a)
+}
+
+// dump writes the whole dump as a single entry, so that it's not interleaved with
+// entries logged concurrently
+func (log *Logger) dump(level int, label string, a interface{}) {
+	if !log.IsLogging(level) {
 		return
 	}
 
-	var b strings.Builder
-	fmt.Fprintln(&b, label)
+	visited := make(map[dumpVisit]bool)
+	value := reflect.ValueOf(a)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		visited[dumpVisit{value.Pointer(), value.Type()}] = true
+		value = value.Elem()
+	}
 
-	dumpHelper(&b, 2, objectType, a)
+	var b strings.Builder
+	switch value.Kind() {
+	case reflect.Struct:
+		fmt.Fprintln(&b, label)
+		dumpStruct(&b, 2, value, visited)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		dumpHelper(&b, 0, label, value, visited)
+	default:
+		log.output(4, time.Now(), level, nil, "Dump was called with an object that wasn't a struct, slice, array or map\n")
+		return
+	}
 
-	log.printfAlways("%s", b.String())
+	log.output(4, time.Now(), level, nil, "%s", b.String())
 }
 
-func dumpHelper(writer io.Writer, indent int, objectType reflect.Type, a interface{}) {
-	var padBuilder strings.Builder
-	padBuilder.Grow(indent)
-	for i := 0; i < indent; i++ {
-		padBuilder.WriteByte(' ')
-	}
-	padding := padBuilder.String()
+// maxDumpDepth is the depth at which Dump stops expanding nested values
+const maxDumpDepth = 16
+
+// dumpVisit identifies a pointer or map being dumped, to detect cycles
+type dumpVisit struct {
+	pointer   uintptr
+	valueType reflect.Type
+}
 
-	objectValue := reflect.ValueOf(a)
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
 
+func dumpStruct(writer io.Writer, indent int, value reflect.Value, visited map[dumpVisit]bool) {
+	objectType := value.Type()
 	fieldCount := objectType.NumField()
 	for fieldIndex := 0; fieldIndex < fieldCount; fieldIndex++ {
 		field := objectType.Field(fieldIndex)
-		value := objectValue.Field(fieldIndex)
-		if field.Type.Kind() == reflect.Struct {
-			fmt.Fprintf(writer, "%s%s:\n", padding, field.Name)
-			dumpHelper(writer, indent+2, field.Type, value.Interface())
-		} else {
-			fmt.Fprintf(writer, "%s%s  %v\n", padding, field.Name, value)
+		if field.Tag.Get("log") == "redact" {
+			fmt.Fprintf(writer, "%s%s  ****\n", strings.Repeat(" ", indent), field.Name)
+			continue
 		}
+		dumpHelper(writer, indent, field.Name, value.Field(fieldIndex), visited)
 	}
 }
 
-// StackTrace will log the current stack trace
+// dumpHelper writes a named value, expanding structs, slices, arrays, maps and pointers
+// up to maxDumpDepth levels deep. Pointers and maps already being dumped are written
+// as <cycle>.
+func dumpHelper(writer io.Writer, indent int, name string, value reflect.Value, visited map[dumpVisit]bool) {
+	padding := strings.Repeat(" ", indent)
+	if indent/2 > maxDumpDepth {
+		fmt.Fprintf(writer, "%s%s  ...\n", padding, name)
+		return
+	}
+
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			fmt.Fprintf(writer, "%s%s  <nil>\n", padding, name)
+			return
+		}
+		if value.Kind() == reflect.Ptr {
+			visit := dumpVisit{value.Pointer(), value.Type()}
+			if visited[visit] {
+				fmt.Fprintf(writer, "%s%s  <cycle>\n", padding, name)
+				return
+			}
+			visited[visit] = true
+			defer delete(visited, visit)
+		}
+		dumpHelper(writer, indent, name, value.Elem(), visited)
+
+	case reflect.Struct:
+		if value.Type().Implements(stringerType) && value.CanInterface() {
+			fmt.Fprintf(writer, "%s%s  %v\n", padding, name, value)
+			return
+		}
+		fmt.Fprintf(writer, "%s%s:\n", padding, name)
+		dumpStruct(writer, indent+2, value, visited)
+
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			fmt.Fprintf(writer, "%s%s  <nil>\n", padding, name)
+			return
+		}
+		if value.Len() == 0 || value.Type().Elem().Kind() == reflect.Uint8 {
+			fmt.Fprintf(writer, "%s%s  %v\n", padding, name, value)
+			return
+		}
+		fmt.Fprintf(writer, "%s%s:\n", padding, name)
+		for index := 0; index < value.Len(); index++ {
+			dumpHelper(writer, indent+2, fmt.Sprintf("[%d]", index), value.Index(index), visited)
+		}
+
+	case reflect.Map:
+		if value.IsNil() {
+			fmt.Fprintf(writer, "%s%s  <nil>\n", padding, name)
+			return
+		}
+		if value.Len() == 0 {
+			fmt.Fprintf(writer, "%s%s  %v\n", padding, name, value)
+			return
+		}
+		visit := dumpVisit{value.Pointer(), value.Type()}
+		if visited[visit] {
+			fmt.Fprintf(writer, "%s%s  <cycle>\n", padding, name)
+			return
+		}
+		visited[visit] = true
+		defer delete(visited, visit)
+
+		keys := value.MapKeys()
+		keyNames := make([]string, len(keys))
+		for index, key := range keys {
+			keyNames[index] = fmt.Sprintf("%v", key)
+		}
+		sort.Sort(byKeyName{keys, keyNames})
+		fmt.Fprintf(writer, "%s%s:\n", padding, name)
+		for index, key := range keys {
+			dumpHelper(writer, indent+2, "["+keyNames[index]+"]", value.MapIndex(key), visited)
+		}
+
+	default:
+		fmt.Fprintf(writer, "%s%s  %v\n", padding, name, value)
+	}
+}
+
+// byKeyName sorts map keys by their formatted value
+type byKeyName struct {
+	keys  []reflect.Value
+	names []string
+}
+
+func (k byKeyName) Len() int           { return len(k.keys) }
+func (k byKeyName) Less(i, j int) bool { return k.names[i] < k.names[j] }
+func (k byKeyName) Swap(i, j int) {
+	k.keys[i], k.keys[j] = k.keys[j], k.keys[i]
+	k.names[i], k.names[j] = k.names[j], k.names[i]
+}
+
+// defaultStackDepth is the maximum number of frames logged by StackTrace
+const defaultStackDepth = 128
+
+// StackTrace will log the current stack trace, starting at the caller
 func (log *Logger) StackTrace() {
+	if useLogger, useGlog := log.destinations(); !useLogger && !useGlog {
+		return
+	}
+	log.printfAlways("%s", stackTrace(3, defaultStackDepth))
+}
+
+// StackTraceDepth will log the current stack trace of up to max frames (128 if max isn't
+// positive), starting skip frames above the caller. A wrapper of StackTraceDepth passes
+// skip + 1, so that the stack trace starts at the wrapper's caller.
+func (log *Logger) StackTraceDepth(skip int, max int) {
+	if max <= 0 {
+		max = defaultStackDepth
+	}
+	if useLogger, useGlog := log.destinations(); !useLogger && !useGlog {
+		return
+	}
+	log.printfAlways("%s", stackTrace(3+skip, max))
+}
+
+// RecoverAndLog recovers from a panic, logs it with the stack trace at the FATAL level and,
+// if rethrow is true, panics again with the same value. It must be deferred directly:
+//
+//	defer log.RecoverAndLog(false)
+func (log *Logger) RecoverAndLog(rethrow bool) {
+	if r := recover(); r != nil {
+		log.LogPanic(r, rethrow)
+	}
+}
+
+// LogPanic logs the value of a recovered panic with the stack trace at the FATAL level and,
+// if rethrow is true, panics again with the same value. It's meant for wrappers of
+// RecoverAndLog, which must call recover themselves.
+func (log *Logger) LogPanic(r interface{}, rethrow bool) {
+	if log.IsLogging(FATAL) {
+		log.output(3, time.Now(), FATAL, nil, "Recovered from panic: %v\n%s", r, stackTrace(4, defaultStackDepth))
+	}
+	if rethrow {
+		panic(r)
+	}
+}
+
+// stackTrace formats the stack trace of up to max frames, skipping skip frames as
+// runtime.Callers does, 0 being runtime.Callers and 1 stackTrace itself
+func stackTrace(skip int, max int) string {
 	var b strings.Builder
-	pc := make([]uintptr, 128)
-	n := runtime.Callers(3, pc)
+	pc := make([]uintptr, max)
+	n := runtime.Callers(skip, pc)
 	if n == 0 {
-		return
+		return ""
 	}
 	pc = pc[:n]
 	frames := runtime.CallersFrames(pc)
@@ -433,7 +1703,7 @@ func (log *Logger) StackTrace() {
 			break
 		}
 	}
-	log.printfAlways("%s", b.String())
+	return b.String()
 }
 
 func logLevel(stringLevel string) int {
@@ -446,11 +1716,11 @@ func logLevel(stringLevel string) int {
 }
 
 func (log *Logger) lock() {
-	<-log.lockChannel
+	log.mutex.Lock()
 }
 
 func (log *Logger) unLock() {
-	log.lockChannel <- 1
+	log.mutex.Unlock()
 }
 
 // AdjustMaxLogfileSize insures that the max log file size, when the deafult value is chosen
@@ -0,0 +1,744 @@
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testParameters returns the parameters of a logger writing to test.log in a temporary
+// directory, at the INFO level
+func testParameters(t testing.TB, destinations string) Parameters {
+	return Parameters{
+		RootPath:                 t.TempDir(),
+		FileName:                 "test",
+		MaxFileSize:              1024,
+		MaxCompressedFilesNumber: 5,
+		Destinations:             destinations,
+		Level:                    "INFO",
+		MaintenanceInterval:      3600,
+	}
+}
+
+// readLog returns the content of a log file, failing the test if it can't be read
+func readLog(t *testing.T, fileName string) string {
+	t.Helper()
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %s", fileName, err)
+	}
+	return string(content)
+}
+
+func TestInitAfterStop(t *testing.T) {
+	tests := []struct {
+		name       string
+		first      string
+		second     string
+		wantFile   bool
+		wantStdout bool
+		wantGlog   bool
+	}{
+		{"file then stdout", "file", "stdout", false, true, false},
+		{"file then glog", "file,stdout", "glog", false, false, true},
+		{"stdout then file", "stdout", "file", true, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := &Logger{}
+			parameters := testParameters(t, test.first)
+			if err := log.Init(parameters); err != nil {
+				t.Fatal(err)
+			}
+			log.Info("first")
+			log.Stop()
+
+			parameters.Destinations = test.second
+			if err := log.Init(parameters); err != nil {
+				t.Fatal(err)
+			}
+			defer log.Stop()
+			if got := log.CurrentFile != nil; got != test.wantFile {
+				t.Errorf("CurrentFile set = %v, want %v", got, test.wantFile)
+			}
+			if log.Stdout != test.wantStdout {
+				t.Errorf("Stdout = %v, want %v", log.Stdout, test.wantStdout)
+			}
+			if log.glog.Load() != test.wantGlog {
+				t.Errorf("glog = %v, want %v", log.glog.Load(), test.wantGlog)
+			}
+			if got := log.ticker != nil; got != test.wantFile {
+				t.Errorf("maintenance started = %v, want %v", got, test.wantFile)
+			}
+			if log.LastError() != nil {
+				t.Errorf("LastError = %v, want nil", log.LastError())
+			}
+		})
+	}
+}
+
+func TestInitStopWhileLogging(t *testing.T) {
+	log := &Logger{}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			log.Info("info")
+			log.WithFields(map[string]interface{}{"key": "value"}).Warning("warning")
+			log.LogCounters(map[string]int64{"sent": 1})
+			log.IsLogging(DEBUG)
+			log.Flush()
+		}
+	}()
+
+	// Each Init replaces the state of the logger, which is still being used
+	formats := []string{TextFormat, JSONFormat, TextJSONFormat}
+	for i := 0; i < 20; i++ {
+		parameters := testParameters(t, "file")
+		parameters.Format = formats[i%len(formats)]
+		parameters.BufferSize = 1024
+		if err := log.Init(parameters); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+		log.Stop()
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// BenchmarkDisabled measures the entries at a level that isn't logged, which must neither
+// allocate nor lock, in parallel to show that the goroutines don't contend
+func BenchmarkDisabled(b *testing.B) {
+	log := &Logger{}
+	if err := log.Init(testParameters(b, "file")); err != nil {
+		b.Fatal(err)
+	}
+	defer log.Stop()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			log.Debug("disabled")
+		}
+	})
+}
+
+func TestDiscard(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(log *Logger)
+	}{
+		{"Info", func(log *Logger) { log.Info("message") }},
+		{"ErrorRateLimited", func(log *Logger) { log.ErrorRateLimited("key", time.Minute, "message") }},
+		{"LogFunc", func(log *Logger) {
+			log.LogFunc(ERROR, func() string {
+				t.Error("LogFunc evaluated its message")
+				return "message"
+			})
+		}},
+		{"Dump", func(log *Logger) { log.Dump("label", struct{ A int }{1}) }},
+		{"StackTrace", func(log *Logger) { log.StackTrace() }},
+		{"Flush", func(log *Logger) { log.Flush() }},
+		{"Reopen", func(log *Logger) { log.Reopen() }},
+		{"Stop", func(log *Logger) { log.Stop() }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := Discard()
+			log.SetLevel(INFO)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				test.call(log)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the Discard logger blocked")
+			}
+			if log.IsLogging(ERROR) || log.GetLevel() != NONE {
+				t.Errorf("IsLogging(ERROR) = %v, GetLevel = %d, want false and NONE", log.IsLogging(ERROR), log.GetLevel())
+			}
+			if counts := log.Counts(); counts[ERROR] != 0 || counts[INFO] != 0 {
+				t.Errorf("Counts = %v, want no entries", counts)
+			}
+		})
+	}
+}
+
+// countLines returns the number of lines containing text in the log files and the rotated
+// log files of a directory, decompressing the gzip archives
+func countLines(t *testing.T, dir string, text string) int {
+	t.Helper()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, file := range files {
+		f, err := os.Open(filepath.Join(dir, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = f
+		if strings.HasSuffix(file.Name(), ".gz") {
+			if r, err = gzip.NewReader(f); err != nil {
+				t.Fatalf("Failed to decompress %s: %s", file.Name(), err)
+			}
+		}
+		content, err := ioutil.ReadAll(r)
+		f.Close()
+		if err != nil {
+			t.Fatalf("Failed to read %s: %s", file.Name(), err)
+		}
+		count += strings.Count(string(content), text)
+	}
+	return count
+}
+
+// rotateWhile logs lines while the maintenance checks rotate the log file and concurrently is
+// called over and over, and returns the number of lines logged
+func rotateWhile(t *testing.T, log *Logger, concurrently func()) int {
+	const lines = 300
+	var wg, started sync.WaitGroup
+	done := make(chan struct{})
+	loop := func(f func(), yield bool) {
+		defer wg.Done()
+		started.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				f()
+				if yield {
+					runtime.Gosched()
+				}
+			}
+		}
+	}
+	wg.Add(2)
+	started.Add(2)
+	go loop(log.maintain, true)
+	go loop(concurrently, false)
+	started.Wait()
+	for i := 0; i < lines; i++ {
+		log.Info("line %d of the test of rotating the log file concurrently", i)
+		if i%10 == 0 {
+			runtime.Gosched()
+		}
+	}
+	close(done)
+	wg.Wait()
+	return lines
+}
+
+func TestReopenDuringRotation(t *testing.T) {
+	tests := []struct {
+		name       string
+		bufferSize int
+	}{
+		{"unbuffered", 0},
+		{"buffered", 4096},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := &Logger{}
+			parameters := testParameters(t, "file")
+			parameters.MaxFileSize = 1
+			parameters.MaxCompressedFilesNumber = 1000
+			parameters.BufferSize = test.bufferSize
+			if err := log.Init(parameters); err != nil {
+				t.Fatal(err)
+			}
+			logged := rotateWhile(t, log, func() {
+				if err := log.Reopen(); err != nil {
+					t.Error(err)
+				}
+			})
+			log.Stop()
+
+			if err := log.LastError(); err != nil {
+				t.Errorf("LastError = %v, want nil", err)
+			}
+			if files, _ := ioutil.ReadDir(parameters.RootPath); len(files) < 2 {
+				t.Error("the log file wasn't rotated")
+			}
+			if got := countLines(t, parameters.RootPath, "concurrently"); got != logged {
+				t.Errorf("found %d lines in the log files, want %d", got, logged)
+			}
+		})
+	}
+}
+
+func TestSetWritersDuringRotation(t *testing.T) {
+	tests := []struct {
+		name       string
+		bufferSize int
+	}{
+		{"unbuffered", 0},
+		{"buffered", 4096},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := &Logger{}
+			parameters := testParameters(t, "file")
+			parameters.MaxFileSize = 1
+			parameters.MaxCompressedFilesNumber = 1000
+			parameters.BufferSize = test.bufferSize
+			if err := log.Init(parameters); err != nil {
+				t.Fatal(err)
+			}
+			// The previous writers are left to the caller to close
+			var previous *os.File
+			fileName := filepath.Join(parameters.RootPath, "test.log")
+			rotateWhile(t, log, func() {
+				f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := log.SetWriters(f); err != nil {
+					t.Error(err)
+				}
+				if previous != nil {
+					previous.Close()
+				}
+				previous = f
+			})
+			log.Stop()
+
+			if err := log.LastError(); err != nil {
+				t.Errorf("LastError = %v, want nil", err)
+			}
+			if files, _ := ioutil.ReadDir(parameters.RootPath); len(files) < 2 {
+				t.Error("the log file wasn't rotated")
+			}
+		})
+	}
+}
+
+func TestMaxTotalLogBytes(t *testing.T) {
+	tests := []struct {
+		name        string
+		rotated     bool
+		compressing bool
+		want        []string
+	}{
+		{"archives", false, false, []string{"test.log", "test.log.1.gz", "test.log.2.gz"}},
+		{"rotated log file left uncompressed", true, false, []string{"test.log", "test.log.1", "test.log.1.gz"}},
+		{"compressing", false, true, []string{"test.log", "test.log.1.gz", "test.log.2.gz", "test.log.3.gz"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := &Logger{}
+			parameters := testParameters(t, "file")
+			parameters.MaxTotalLogBytes = 2500
+			parameters.MaxCompressedFilesNumber = 10
+			if err := log.Init(parameters); err != nil {
+				t.Fatal(err)
+			}
+			defer log.Stop()
+			fileName := filepath.Join(parameters.RootPath, "test.log")
+			archives := []string{fileName + ".1.gz", fileName + ".2.gz", fileName + ".3.gz"}
+			if test.rotated {
+				archives = append(archives, fileName+".1")
+			}
+			for _, archive := range archives {
+				if err := ioutil.WriteFile(archive, make([]byte, 1000), 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.compressing {
+				log.compressing = make(chan struct{})
+				defer close(log.compressing)
+			}
+
+			log.maintain()
+			files, _ := ioutil.ReadDir(parameters.RootPath)
+			var names []string
+			for _, file := range files {
+				names = append(names, file.Name())
+			}
+			if strings.Join(names, " ") != strings.Join(test.want, " ") {
+				t.Errorf("files = %v, want %v", names, test.want)
+			}
+		})
+	}
+}
+
+func TestReopenWithBuffer(t *testing.T) {
+	tests := []struct {
+		name       string
+		bufferSize int
+		before     int
+		after      int
+	}{
+		{"unbuffered", 0, 10, 10},
+		{"buffer not full", 1 << 20, 10, 10},
+		{"buffer flushed when full", 512, 100, 10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := &Logger{}
+			parameters := testParameters(t, "file")
+			parameters.BufferSize = test.bufferSize
+			if err := log.Init(parameters); err != nil {
+				t.Fatal(err)
+			}
+			fileName := filepath.Join(parameters.RootPath, "test.log")
+			for i := 0; i < test.before; i++ {
+				log.Info("before reopen %d", i)
+			}
+			// Like logrotate, which renames the log file before signaling the process
+			if err := os.Rename(fileName, fileName+".rotated"); err != nil {
+				t.Fatal(err)
+			}
+			if err := log.Reopen(); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < test.after; i++ {
+				log.Info("after reopen %d", i)
+			}
+			log.Stop()
+
+			rotated := readLog(t, fileName+".rotated")
+			current := readLog(t, fileName)
+			if got := strings.Count(rotated, "before reopen"); got != test.before || strings.Contains(rotated, "after reopen") {
+				t.Errorf("the log file before Reopen has %d lines logged before, want %d and none after:\n%s",
+					got, test.before, rotated)
+			}
+			if got := strings.Count(current, "after reopen"); got != test.after || strings.Contains(current, "before reopen") {
+				t.Errorf("the log file after Reopen has %d lines logged after, want %d and none before:\n%s",
+					got, test.after, current)
+			}
+		})
+	}
+}
+
+func TestSyncWrites(t *testing.T) {
+	tests := []struct {
+		name       string
+		syncWrites bool
+		bufferSize int
+	}{
+		{"sync writes", true, 0},
+		{"buffered by the OS", false, 0},
+		{"sync writes ignore the buffer", true, 4096},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := &Logger{}
+			parameters := testParameters(t, "file")
+			parameters.SyncWrites = test.syncWrites
+			parameters.BufferSize = test.bufferSize
+			if err := log.Init(parameters); err != nil {
+				t.Fatal(err)
+			}
+			defer log.Stop()
+			if synced := log.fileFlags&os.O_SYNC != 0; synced != test.syncWrites {
+				t.Errorf("O_SYNC is %v in the open flags, expected %v", synced, test.syncWrites)
+			}
+			if log.fileFlags&(os.O_WRONLY|os.O_CREATE|os.O_APPEND) != os.O_WRONLY|os.O_CREATE|os.O_APPEND {
+				t.Errorf("The open flags %x don't include O_WRONLY, O_CREATE and O_APPEND", log.fileFlags)
+			}
+			if log.buffer != nil {
+				t.Error("The log file is buffered")
+			}
+			log.Info("durable")
+			if content := readLog(t, log.fileName); !strings.Contains(content, "durable") {
+				t.Errorf("The log file contains %q", content)
+			}
+		})
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval int
+		format   string
+		expected []string
+	}{
+		{"text", 1, TextFormat, []string{"STATUS: heartbeat", "start=", "uptime="}},
+		{"json", 1, JSONFormat, []string{`"msg":"heartbeat"`, `"start":`, `"uptime":`}},
+		{"disabled", 0, TextFormat, nil},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			log := &Logger{}
+			parameters := testParameters(t, "file")
+			parameters.Format = test.format
+			parameters.HeartbeatInterval = test.interval
+			if err := log.Init(parameters); err != nil {
+				t.Fatal(err)
+			}
+			fileName := log.fileName
+			deadline := time.Now().Add(1500 * time.Millisecond)
+			if test.expected != nil {
+				deadline = time.Now().Add(5 * time.Second)
+			}
+			for time.Now().Before(deadline) && !strings.Contains(readLog(t, fileName), "heartbeat") {
+				time.Sleep(50 * time.Millisecond)
+			}
+			log.Stop()
+
+			content := readLog(t, fileName)
+			if test.expected == nil {
+				if content != "" {
+					t.Errorf("Logged %q with heartbeats disabled", content)
+				}
+				return
+			}
+			for _, expected := range test.expected {
+				if !strings.Contains(content, expected) {
+					t.Errorf("%q doesn't contain %s", content, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestInitWithWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		level    int
+		tracing  bool
+		expected []string
+	}{
+		{"warning level", WARNING, false, []string{"ERROR: error", "WARNING: warning"}},
+		{"debug level", DEBUG, false, []string{"ERROR: error", "WARNING: warning", "INFO: info", "DEBUG: debug"}},
+		{"tracing prefix", ERROR, true, []string{"* "}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b bytes.Buffer
+			log := &Logger{Tracing: test.tracing}
+			if err := log.InitWithWriter(&b, test.level); err != nil {
+				t.Fatal(err)
+			}
+			if err := log.InitWithWriter(&b, test.level); err == nil {
+				t.Error("A second InitWithWriter didn't fail")
+			}
+			log.Error("error")
+			log.Warning("warning")
+			log.Info("info")
+			log.Debug("debug")
+			log.Trace("trace")
+			log.Stop()
+
+			lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+			if len(lines) != len(test.expected) {
+				t.Fatalf("Logged %q, expected %d lines", lines, len(test.expected))
+			}
+			for i, expected := range test.expected {
+				if !strings.Contains(lines[i], expected) {
+					t.Errorf("%q doesn't contain %q", lines[i], expected)
+				}
+			}
+		})
+	}
+}
+
+func TestInitWithWriterConcurrently(t *testing.T) {
+	log := &Logger{}
+	if err := log.InitWithWriter(nil, INFO); err == nil {
+		t.Error("InitWithWriter accepted a nil writer")
+	}
+
+	// Logging from several goroutines to a buffer, which isn't safe for concurrent use, is
+	// serialized by the logger's lock
+	var b bytes.Buffer
+	if err := log.InitWithWriter(&b, INFO); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				log.Info("concurrent")
+			}
+		}()
+	}
+	wg.Wait()
+	log.Stop()
+	if count := strings.Count(b.String(), "INFO: concurrent\n"); count != 200 {
+		t.Errorf("Logged %d lines, expected 200", count)
+	}
+}
+
+func TestInvalidDestinations(t *testing.T) {
+	tests := []struct {
+		name         string
+		destinations string
+		required     string
+		wantErr      string
+	}{
+		{"empty", "", "", "destination '' "},
+		{"whitespace", "  ", "", "destination '' "},
+		{"unknown token", "file,kafka", "", "destination 'kafka' "},
+		{"empty token", "file,,stdout", "", "destination '' "},
+		{"unknown required token", "file", "mqtt", "destination 'mqtt' "},
+		{"spaces around valid tokens", " file , stdout ", "", ""},
+		{"case insensitive", "FILE", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := &Logger{}
+			parameters := testParameters(t, test.destinations)
+			parameters.RequiredDestinations = test.required
+			err := log.Init(parameters)
+			if err == nil {
+				log.Stop()
+			}
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("Init returned %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Init returned %v, expected an error about %s", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// writeArchive writes a gzip compressed rotated log file containing text
+func writeArchive(t *testing.T, fileName string, text string) {
+	t.Helper()
+	f, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := gzip.NewWriter(f)
+	w.Write([]byte(text))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
+
+// readArchive returns the content of a gzip compressed rotated log file, or "" if it's missing
+func readArchive(t *testing.T, fileName string) string {
+	t.Helper()
+	f, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return ""
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Failed to decompress %s: %s", fileName, err)
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to decompress %s: %s", fileName, err)
+	}
+	return string(content)
+}
+
+func TestInterruptedRotation(t *testing.T) {
+	tests := []struct {
+		name    string
+		failing string
+		shifted bool
+	}{
+		{"renaming the oldest archive", "test.log.3.gz", false},
+		{"renaming a middle archive", "test.log.2.gz", false},
+		{"renaming the newest archive", "test.log.1.gz", false},
+		{"renaming the log file", "test.log", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := &Logger{}
+			parameters := testParameters(t, "file")
+			parameters.MaxFileSize = 1
+			parameters.MaxCompressedFilesNumber = 10
+			if err := log.Init(parameters); err != nil {
+				t.Fatal(err)
+			}
+			defer log.Stop()
+			var errs []error
+			log.OnInternalError(func(err error) { errs = append(errs, err) })
+			fileName := filepath.Join(parameters.RootPath, "test.log")
+			for i := 1; i <= 3; i++ {
+				writeArchive(t, fmt.Sprintf("%s.%d.gz", fileName, i), fmt.Sprintf("archive %d\n", i))
+			}
+
+			failed := false
+			rename = func(oldPath string, newPath string) error {
+				if filepath.Base(oldPath) == test.failing && !failed {
+					failed = true
+					return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: syscall.EACCES}
+				}
+				return os.Rename(oldPath, newPath)
+			}
+			defer func() { rename = os.Rename }()
+
+			for i := 0; i < 20; i++ {
+				log.Info("line %d logged before the interrupted rotation", i)
+			}
+			log.maintain()
+			if len(errs) == 0 || log.LastError() == nil {
+				t.Error("The failed rotation wasn't reported")
+			}
+
+			// No archive is lost nor overwritten, and the log file is still written to
+			for i := 1; i <= 4; i++ {
+				expected := ""
+				if test.shifted && i > 1 {
+					expected = fmt.Sprintf("archive %d\n", i-1)
+				} else if !test.shifted && i < 4 {
+					expected = fmt.Sprintf("archive %d\n", i)
+				}
+				if content := readArchive(t, fmt.Sprintf("%s.%d.gz", fileName, i)); content != expected {
+					t.Errorf("Archive %d contains %q after the failure, expected %q", i, content, expected)
+				}
+			}
+			for i := 0; i < 5; i++ {
+				log.Info("line %d logged before the interrupted rotation is retried", i)
+			}
+			log.maintain()
+			log.Stop()
+
+			for i := 2; i <= 4; i++ {
+				expected := fmt.Sprintf("archive %d\n", i-1)
+				if content := readArchive(t, fmt.Sprintf("%s.%d.gz", fileName, i)); content != expected {
+					t.Errorf("Archive %d contains %q after the retry, expected %q", i, content, expected)
+				}
+			}
+			if count := countLines(t, parameters.RootPath, "logged before the interrupted rotation\n"); count != 20 {
+				t.Errorf("Found %d of the lines logged before the failure, expected 20", count)
+			}
+			if count := countLines(t, parameters.RootPath, "is retried\n"); count != 5 {
+				t.Errorf("Found %d of the lines logged after the failure, expected 5", count)
+			}
+			if content := readArchive(t, fileName+".1.gz"); !strings.Contains(content, "is retried") {
+				t.Errorf("The retried rotation didn't rotate the log file, archive 1 contains %q", content)
+			}
+		})
+	}
+}
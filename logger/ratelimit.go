@@ -0,0 +1,163 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxRateLimitKeys bounds the number of keys whose repetitions are tracked. When it's
+// reached, keys whose window expired are forgotten, and if none did, messages with new
+// keys are logged without being rate limited.
+const maxRateLimitKeys = 1024
+
+// rateLimit tracks the repetitions of a rate limited message, and the last one suppressed
+type rateLimit struct {
+	logged     time.Time
+	every      time.Duration
+	suppressed int
+	level      int
+	format     string
+	a          []interface{}
+}
+
+// rateLimitSummary is a message logged with the number of times it was suppressed
+type rateLimitSummary struct {
+	level      int
+	format     string
+	a          []interface{}
+	suppressed int
+}
+
+// LogRateLimited logs at the specified level at most once per every for the key, suppressing
+// the messages logged with the same key in between. The last message suppressed is logged
+// suffixed with "(repeated N times)", N being the number of messages suppressed since the
+// previous one was logged: at the first maintenance check once the window expired, when the
+// key is forgotten to make room for others, and when the logger is stopped. If a message is
+// logged with the key before that, it's the one suffixed.
+func (log *Logger) LogRateLimited(level int, key string, every time.Duration, format string, a ...interface{}) {
+	if !log.IsLogging(level) {
+		return
+	}
+	suppressed, logIt, summaries := log.rateLimited(level, key, every, format, a)
+	log.logSummaries(summaries)
+	if !logIt {
+		return
+	}
+	if suppressed > 0 {
+		format = repeatedFormat(format, suppressed)
+	}
+	log.output(4, time.Now(), level, nil, format, a...)
+}
+
+// ErrorRateLimited logs an error at most once per every for the key, see LogRateLimited
+func (log *Logger) ErrorRateLimited(key string, every time.Duration, format string, a ...interface{}) {
+	log.LogRateLimited(ERROR, key, every, format, a...)
+}
+
+// WarningRateLimited logs a warning at most once per every for the key, see LogRateLimited
+func (log *Logger) WarningRateLimited(key string, every time.Duration, format string, a ...interface{}) {
+	log.LogRateLimited(WARNING, key, every, format, a...)
+}
+
+// rateLimited returns whether a message with the key is to be logged now, how many messages
+// with the key were suppressed since the last one was logged, and the summaries of the keys
+// forgotten to make room for the key
+func (log *Logger) rateLimited(level int, key string, every time.Duration, format string,
+	a []interface{}) (int, bool, []rateLimitSummary) {
+	now := time.Now()
+	log.lock()
+	defer log.unLock()
+
+	if log.rateLimits == nil {
+		log.rateLimits = make(map[string]*rateLimit)
+	}
+	limit, found := log.rateLimits[key]
+	if !found {
+		var summaries []rateLimitSummary
+		if len(log.rateLimits) >= maxRateLimitKeys {
+			for name, l := range log.rateLimits {
+				if now.Sub(l.logged) >= l.every {
+					if l.suppressed > 0 {
+						summaries = append(summaries, l.summary())
+					}
+					delete(log.rateLimits, name)
+				}
+			}
+			if len(log.rateLimits) >= maxRateLimitKeys {
+				return 0, true, summaries
+			}
+		}
+		log.rateLimits[key] = &rateLimit{logged: now, every: every}
+		return 0, true, summaries
+	}
+
+	if now.Sub(limit.logged) < every {
+		limit.suppressed++
+		limit.level, limit.format, limit.a = level, format, a
+		return 0, false, nil
+	}
+	suppressed := limit.suppressed
+	limit.logged = now
+	limit.every = every
+	limit.suppressed = 0
+	limit.a = nil
+	return suppressed, true, nil
+}
+
+// summary returns the summary of the messages suppressed for the key
+func (limit *rateLimit) summary() rateLimitSummary {
+	return rateLimitSummary{level: limit.level, format: limit.format, a: limit.a, suppressed: limit.suppressed}
+}
+
+// summarize logs the summaries of the rate limited messages at each interval, until done is
+// closed. It's the maintenance of the loggers without a log file.
+func (log *Logger) summarize(interval time.Duration, done chan struct{}) {
+	defer log.maintenance.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			log.logRateLimitSummaries(false)
+		case <-done:
+			return
+		}
+	}
+}
+
+// logRateLimitSummaries logs the summaries of the messages suppressed for the keys whose window
+// expired, or for all the keys if all is true, and forgets the keys whose window expired with
+// no message suppressed
+func (log *Logger) logRateLimitSummaries(all bool) {
+	now := time.Now()
+	var summaries []rateLimitSummary
+	log.lock()
+	for key, limit := range log.rateLimits {
+		expired := now.Sub(limit.logged) >= limit.every
+		if limit.suppressed > 0 && (all || expired) {
+			summaries = append(summaries, limit.summary())
+			limit.logged = now
+			limit.suppressed = 0
+			limit.a = nil
+		} else if expired {
+			delete(log.rateLimits, key)
+		}
+	}
+	log.unLock()
+	log.logSummaries(summaries)
+}
+
+// logSummaries logs the summaries of suppressed messages. Must be called with the logger
+// unlocked.
+func (log *Logger) logSummaries(summaries []rateLimitSummary) {
+	for _, summary := range summaries {
+		log.output(4, time.Now(), summary.level, nil, repeatedFormat(summary.format, summary.suppressed), summary.a...)
+	}
+}
+
+// repeatedFormat returns the format of a message suffixed with the number of times it was
+// suppressed
+func repeatedFormat(format string, suppressed int) string {
+	return fmt.Sprintf("%s (repeated %d times)\n", strings.TrimRight(format, "\n"), suppressed)
+}
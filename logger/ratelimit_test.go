@@ -0,0 +1,112 @@
+package logger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitSummaries(t *testing.T) {
+	const every = 50 * time.Millisecond
+	tests := []struct {
+		name string
+		then func(log *Logger)
+		want []string
+	}{
+		{"within the window", func(log *Logger) { log.maintain() },
+			[]string{"ERROR: flapping 0"}},
+		{"maintenance check after the window", func(log *Logger) {
+			time.Sleep(every)
+			log.maintain()
+		}, []string{"ERROR: flapping 0", "ERROR: flapping 4 (repeated 4 times)"}},
+		{"next message after the window", func(log *Logger) {
+			time.Sleep(every)
+			log.ErrorRateLimited("sensor", every, "flapping %d\n", 5)
+		}, []string{"ERROR: flapping 0", "ERROR: flapping 5 (repeated 4 times)"}},
+		{"stop", func(log *Logger) { log.Stop() },
+			[]string{"ERROR: flapping 0", "ERROR: flapping 4 (repeated 4 times)"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			log := &Logger{}
+			if err := log.InitWithWriter(&output, INFO); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 5; i++ {
+				log.ErrorRateLimited("sensor", every, "flapping %d\n", i)
+			}
+			test.then(log)
+			logged := output.String()
+			log.Stop()
+
+			lines := strings.Split(strings.TrimSuffix(logged, "\n"), "\n")
+			if len(lines) != len(test.want) {
+				t.Fatalf("logged %q, want %d lines", lines, len(test.want))
+			}
+			for i, want := range test.want {
+				if !strings.HasSuffix(lines[i], want) {
+					t.Errorf("line %d = %q, want %q", i, lines[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitSummariesWithoutFile(t *testing.T) {
+	log := &Logger{}
+	parameters := testParameters(t, "stdout")
+	parameters.MaintenanceInterval = 1
+	output := captureStdout(t, func() {
+		if err := log.Init(parameters); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			log.ErrorRateLimited("sensor", 50*time.Millisecond, "flapping %d\n", i)
+		}
+		time.Sleep(1500 * time.Millisecond)
+		log.Info("stopping")
+		log.Stop()
+	})
+
+	// The summary is logged by the maintenance, not by Stop
+	want := []string{"ERROR: flapping 0", "ERROR: flapping 4 (repeated 4 times)", "INFO: stopping"}
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("logged %q, want %d lines", lines, len(want))
+	}
+	for i := range want {
+		if !strings.HasSuffix(lines[i], want[i]) {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+}
+
+func TestRateLimitEviction(t *testing.T) {
+	var output bytes.Buffer
+	log := &Logger{}
+	if err := log.InitWithWriter(&output, INFO); err != nil {
+		t.Fatal(err)
+	}
+	defer log.Stop()
+	log.ErrorRateLimited("evicted", time.Millisecond, "evicted")
+	log.ErrorRateLimited("evicted", time.Millisecond, "evicted")
+	time.Sleep(time.Millisecond)
+	for i := 1; i < maxRateLimitKeys; i++ {
+		log.ErrorRateLimited(strings.Repeat("k", i), time.Hour, "key %d", i)
+	}
+	output.Reset()
+	log.ErrorRateLimited("new key", time.Hour, "new key")
+
+	want := []string{"ERROR: evicted (repeated 1 times)", "ERROR: new key"}
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("logged %q, want %d lines", lines, len(want))
+	}
+	for i := range want {
+		if !strings.HasSuffix(lines[i], want[i]) {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+}
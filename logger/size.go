@@ -0,0 +1,35 @@
+package logger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are the multipliers of the size units accepted by ParseSize
+var sizeUnits = map[string]int64{
+	"": 1, "B": 1,
+	"K": 1 << 10, "KB": 1 << 10, "KIB": 1 << 10,
+	"M": 1 << 20, "MB": 1 << 20, "MIB": 1 << 20,
+	"G": 1 << 30, "GB": 1 << 30, "GIB": 1 << 30,
+}
+
+// ParseSize parses a size in bytes, optionally followed by a unit, B, KB, MB or GB, case
+// insensitively and with or without a space, for example "512KB" or "10 MB". The units are
+// powers of 1024, and K, M, G, KiB, MiB and GiB are accepted too.
+func ParseSize(size string) (int64, error) {
+	trimmed := strings.TrimSpace(size)
+	end := len(trimmed)
+	for end > 0 && (trimmed[end-1] < '0' || trimmed[end-1] > '9') {
+		end--
+	}
+	multiplier, found := sizeUnits[strings.ToUpper(strings.TrimSpace(trimmed[end:]))]
+	value, err := strconv.ParseInt(trimmed[:end], 10, 64)
+	if !found || err != nil || value < 0 {
+		return 0, &Error{fmt.Sprintf("Invalid size: %s\n", size)}
+	}
+	if value > (1<<63-1)/multiplier {
+		return 0, &Error{fmt.Sprintf("Size %s is too large\n", size)}
+	}
+	return value * multiplier, nil
+}
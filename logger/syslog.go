@@ -0,0 +1,294 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of messages sent to a remote syslog server
+const (
+	RFC3164 = "rfc3164"
+	RFC5424 = "rfc5424"
+)
+
+// Timing of the connection to a remote syslog server
+const (
+	syslogDialTimeout    = 5 * time.Second
+	syslogWriteTimeout   = 5 * time.Second
+	syslogReconnectDelay = 10 * time.Second
+)
+
+// logLevel2syslog maps log levels to syslog priorities. Dumps and stack traces, which have
+// no level, are logged with LOG_NOTICE like STATUS.
+var logLevel2syslog = []syslog.Priority{syslog.LOG_NOTICE, syslog.LOG_NOTICE, syslog.LOG_CRIT, syslog.LOG_ERR,
+	syslog.LOG_WARNING, syslog.LOG_INFO, syslog.LOG_DEBUG, syslog.LOG_DEBUG}
+
+// isLeveledSyslog returns true if the writer is a syslog writer the logger writes to with
+// the priority of each line's level, instead of through its Write method
+func isLeveledSyslog(w io.Writer) bool {
+	switch w.(type) {
+	case *syslog.Writer, *remoteSyslog:
+		return true
+	}
+	return false
+}
+
+// writeSyslog writes a line to the syslog destination with the priority of the level,
+// or with LOG_NOTICE if the level is negative. Must be called with the logger locked.
+func (log *Logger) writeSyslog(level int, line string) {
+	priority := syslog.LOG_NOTICE
+	if level >= 0 && level < len(logLevel2syslog) {
+		priority = logLevel2syslog[level]
+	}
+	switch writer := log.Syslog.(type) {
+	case *remoteSyslog:
+		writer.writePriority(priority, []byte(line))
+	case *syslog.Writer:
+		switch priority {
+		case syslog.LOG_CRIT:
+			writer.Crit(line)
+		case syslog.LOG_ERR:
+			writer.Err(line)
+		case syslog.LOG_WARNING:
+			writer.Warning(line)
+		case syslog.LOG_INFO:
+			writer.Info(line)
+		case syslog.LOG_DEBUG:
+			writer.Debug(line)
+		default:
+			writer.Notice(line)
+		}
+	}
+}
+
+// remoteSyslog writes to a remote syslog server over TCP or UDP. The messages are queued and
+// sent by a goroutine, so that logging never waits for the network. If the connection is lost
+// it is reestablished, at most once every syslogReconnectDelay. While the server is slow or
+// unreachable, up to maxBuffered messages are queued and sent once reconnected, and any
+// others are dropped. Write never fails, so that an unreachable server doesn't stop the other
+// destinations.
+type remoteSyslog struct {
+	network  string
+	address  string
+	tag      string
+	hostname string
+	rfc5424  bool
+
+	queue   chan []byte
+	done    chan struct{}
+	stopped chan struct{}
+	closing sync.Once
+
+	mutex       sync.Mutex
+	conn        net.Conn
+	lastAttempt time.Time
+}
+
+// defaultSyslogBufferLines is the number of messages queued for a remote syslog server when
+// Parameters.SyslogBufferLines isn't positive
+const defaultSyslogBufferLines = 256
+
+// newRemoteSyslog creates a writer to the syslog server at address, which is host:port,
+// optionally preceded by tcp:// or udp:// (tcp is the default) or syslog:// (meaning tcp),
+// and starts its goroutine
+func newRemoteSyslog(address string, format string, tag string, maxBuffered int) (*remoteSyslog, error) {
+	network := "tcp"
+	if index := strings.Index(address, "://"); index != -1 {
+		network = strings.ToLower(address[:index])
+		address = address[index+3:]
+		if network == "syslog" {
+			network = "tcp"
+		}
+	}
+	if network != "tcp" && network != "udp" {
+		return nil, fmt.Errorf("unsupported syslog network %s", network)
+	}
+
+	switch strings.ToLower(format) {
+	case "", RFC3164, RFC5424:
+	default:
+		return nil, fmt.Errorf("unsupported syslog format %s", format)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	if maxBuffered <= 0 {
+		maxBuffered = defaultSyslogBufferLines
+	}
+	w := &remoteSyslog{
+		network:  network,
+		address:  address,
+		tag:      tag,
+		hostname: hostname,
+		rfc5424:  strings.EqualFold(format, RFC5424),
+		queue:    make(chan []byte, maxBuffered),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// connect connects to the syslog server, if not already connected
+func (w *remoteSyslog) connect() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.connectLocked()
+}
+
+func (w *remoteSyslog) connectLocked() error {
+	if w.conn != nil {
+		return nil
+	}
+	w.lastAttempt = time.Now()
+	conn, err := net.DialTimeout(w.network, w.address, syslogDialTimeout)
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	return nil
+}
+
+func (w *remoteSyslog) Write(p []byte) (int, error) {
+	w.writePriority(syslog.LOG_NOTICE, p)
+	return len(p), nil
+}
+
+// writePriority queues a message with the specified priority, or drops it if the queue is full
+func (w *remoteSyslog) writePriority(priority syslog.Priority, p []byte) {
+	message := w.format(priority, strings.TrimRight(string(p), "\n"))
+	select {
+	case w.queue <- message:
+	default:
+	}
+}
+
+// run sends the queued messages until the writer is closed, waiting for the server to be
+// reachable again when sending fails. The messages still queued once closed are sent if
+// the server can be reached, within syslogWriteTimeout.
+func (w *remoteSyslog) run() {
+	defer close(w.stopped)
+	var message []byte
+	for {
+		if message == nil {
+			select {
+			case message = <-w.queue:
+			case <-w.done:
+				w.drain(message)
+				return
+			}
+		}
+		if w.send(message, time.Now().Add(syslogWriteTimeout)) {
+			message = nil
+			continue
+		}
+		retry := time.NewTimer(w.reconnectDelay())
+		select {
+		case <-retry.C:
+		case <-w.done:
+			retry.Stop()
+			w.drain(message)
+			return
+		}
+	}
+}
+
+// drain sends the message not sent yet, if any, and the queued messages, as long as the
+// server can be reached
+func (w *remoteSyslog) drain(message []byte) {
+	deadline := time.Now().Add(syslogWriteTimeout)
+	if message != nil && !w.send(message, deadline) {
+		return
+	}
+	for {
+		select {
+		case message = <-w.queue:
+			if !w.send(message, deadline) {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// reconnectDelay returns how long to wait before connecting to the server again
+func (w *remoteSyslog) reconnectDelay() time.Duration {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return time.Until(w.lastAttempt.Add(syslogReconnectDelay))
+}
+
+// send sends a message, connecting to the server first if needed and not attempted within
+// syslogReconnectDelay, and closes the connection if it fails. Returns false if the message
+// wasn't sent.
+func (w *remoteSyslog) send(message []byte, deadline time.Time) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.conn == nil {
+		if time.Since(w.lastAttempt) < syslogReconnectDelay || w.connectLocked() != nil {
+			return false
+		}
+	}
+	w.conn.SetWriteDeadline(deadline)
+	if _, err := w.conn.Write(message); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return false
+	}
+	return true
+}
+
+// format formats a message in the configured syslog format. Messages sent over TCP are
+// framed, using octet counting for RFC 5424 and a trailing newline for RFC 3164.
+func (w *remoteSyslog) format(priority syslog.Priority, msg string) []byte {
+	priority |= syslog.LOG_USER
+	now := time.Now()
+
+	var message string
+	if w.rfc5424 {
+		message = fmt.Sprintf("<%d>1 %s %s %s %d - - %s", priority, now.Format(time.RFC3339Nano),
+			w.hostname, nilValue(w.tag), os.Getpid(), msg)
+		if w.network == "tcp" {
+			message = fmt.Sprintf("%d %s", len(message), message)
+		}
+	} else {
+		message = fmt.Sprintf("<%d>%s %s %s[%d]: %s", priority, now.Format(time.Stamp),
+			w.hostname, w.tag, os.Getpid(), msg)
+		if w.network == "tcp" {
+			message += "\n"
+		}
+	}
+	return []byte(message)
+}
+
+// Close stops the goroutine, once it sent the queued messages it could, and closes the
+// connection to the syslog server
+func (w *remoteSyslog) Close() error {
+	w.closing.Do(func() { close(w.done) })
+	<-w.stopped
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// nilValue returns the RFC 5424 NILVALUE for empty header fields
+func nilValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,161 @@
+package logger
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syslogServer accepts a connection on a local TCP port and returns its address and the
+// accepted connections
+func syslogServer(t *testing.T) (string, chan net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	conns := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conns <- conn
+		}
+	}()
+	return listener.Addr().String(), conns
+}
+
+func TestRemoteSyslog(t *testing.T) {
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{RFC3164, []string{"<11>", "test[", "]: ", "ERROR: remote message\n"}},
+		{RFC5424, []string{"<11>1 ", " test ", "ERROR: remote message"}},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			address, conns := syslogServer(t)
+			log := &Logger{}
+			parameters := testParameters(t, "syslog")
+			parameters.SyslogAddress = address
+			parameters.SyslogFormat = test.format
+			if err := log.Init(parameters); err != nil {
+				t.Fatal(err)
+			}
+			log.Error("remote message")
+			log.Stop()
+
+			var conn net.Conn
+			select {
+			case conn = <-conns:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the logger didn't connect to the syslog server")
+			}
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			received, _ := ioutil.ReadAll(conn)
+			conn.Close()
+			for _, want := range test.want {
+				if !strings.Contains(string(received), want) {
+					t.Errorf("received %q, want it to contain %q", received, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoteSyslogDoesntBlock(t *testing.T) {
+	tests := []struct {
+		name        string
+		bufferLines int
+	}{
+		{"default queue", 0},
+		{"small queue", 10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The server doesn't read, so that the writes block once the socket buffers are full
+			address, conns := syslogServer(t)
+			log := &Logger{}
+			parameters := testParameters(t, "syslog")
+			parameters.SyslogAddress = address
+			parameters.SyslogBufferLines = test.bufferLines
+			if err := log.Init(parameters); err != nil {
+				t.Fatal(err)
+			}
+			line := strings.Repeat("x", 1024)
+			start := time.Now()
+			for i := 0; i < 20000; i++ {
+				log.Info("%s", line)
+			}
+			if elapsed := time.Since(start); elapsed > syslogWriteTimeout {
+				t.Errorf("logging took %s, it waited for the syslog server", elapsed)
+			}
+			select {
+			case conn := <-conns:
+				conn.Close()
+			case <-time.After(5 * time.Second):
+				t.Error("the logger didn't connect to the syslog server")
+			}
+			log.Stop()
+		})
+	}
+}
+
+// closedAddress returns the address of a local TCP port that nothing listens on
+func closedAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	return address
+}
+
+func TestVerifyDestinations(t *testing.T) {
+	tests := []struct {
+		name        string
+		reachable   bool
+		verify      bool
+		required    string
+		wantErr     bool
+		wantSyslog  bool
+		wantWarning bool
+	}{
+		{"reachable required", true, true, "syslog", false, true, false},
+		{"unreachable required", false, true, "syslog", true, false, false},
+		{"unreachable optional", false, true, "", false, false, true},
+		{"unreachable not verified", false, false, "syslog", false, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address := closedAddress(t)
+			if test.reachable {
+				address, _ = syslogServer(t)
+			}
+			log := &Logger{}
+			parameters := testParameters(t, "file,syslog")
+			parameters.SyslogAddress = address
+			parameters.VerifyDestinations = test.verify
+			parameters.RequiredDestinations = test.required
+			err := log.Init(parameters)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Init returned %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if got := log.Syslog != nil; got != test.wantSyslog {
+				t.Errorf("Syslog set = %v, want %v", got, test.wantSyslog)
+			}
+			log.Stop()
+			warned := strings.Contains(readLog(t, log.fileName), "The syslog destination is unreachable")
+			if warned != test.wantWarning {
+				t.Errorf("Warned = %v, want %v", warned, test.wantWarning)
+			}
+		})
+	}
+}
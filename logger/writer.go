@@ -0,0 +1,25 @@
+package logger
+
+import (
+	"io"
+	"strings"
+)
+
+// levelWriter an io.Writer logging each write at a fixed level
+type levelWriter struct {
+	log   *Logger
+	level int
+}
+
+// Writer returns an io.Writer that logs everything written to it at the level, for example
+// to route the output of a standard library log.Logger through this logger with
+// golog.New(myLogger.Writer(logger.ERROR), "", 0). Each write is logged as one entry,
+// without its trailing newline, and is subject to the logger's current level.
+func (log *Logger) Writer(level int) io.Writer {
+	return &levelWriter{log: log, level: level}
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	w.log.printf(w.level, nil, "%s", strings.TrimRight(string(p), "\r\n"))
+	return len(p), nil
+}
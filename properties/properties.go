@@ -2,45 +2,358 @@ package properties
 
 import (
 	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
+// DefaultValueKey is the name of the struct tag holding a field's default value, which is used
+// when neither the field's name nor its metadata tag are found
+var DefaultValueKey = "default"
+
+var timeType = reflect.TypeOf(time.Time{})
+var durationType = reflect.TypeOf(time.Duration(0))
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// converters holds the conversion functions registered with RegisterConverter
+var converters = map[reflect.Type]func(string) (interface{}, error){}
+var convertersMutex sync.RWMutex
+
+// RegisterConverter Registers a function converting values to the type t, for fields of types
+// that aren't supported, such as net.IP, or to parse a supported type differently. The function
+// is called with the value, trimmed of whitespace unless t is a string type, and must return a
+// value assignable or convertible to t. If the type or a pointer to it implements fmt.Stringer,
+// WritePropertiesFile writes the values of its fields with their String method. Struct types
+// with a converter, such as url.URL, are loaded from a single value rather than field by field.
+func RegisterConverter(t reflect.Type, fn func(string) (interface{}, error)) {
+	convertersMutex.Lock()
+	converters[t] = fn
+	convertersMutex.Unlock()
+}
+
+// converter returns the conversion function registered for a type, if any
+func converter(t reflect.Type) (func(string) (interface{}, error), bool) {
+	convertersMutex.RLock()
+	defer convertersMutex.RUnlock()
+	fn, ok := converters[t]
+	return fn, ok
+}
+
+// LoadOptions options controlling how values are matched to the fields of a configuration struct
+type LoadOptions struct {
+	// NormalizeKeys matches keys that aren't found as is ignoring case, dashes and underscores,
+	// so that DeviceId, device-id and DEVICE_ID all match the field DeviceId. Exact matches
+	// take precedence, and if several keys normalize to the same name the first one in
+	// sorted order is used.
+	NormalizeKeys bool
+
+	// Audit, when set, is called for each field of the struct, including the fields of nested
+	// structs and each element of match and indexed fields, with the key its value was found
+	// under and the value. The key is empty when the default value was used, and both the key
+	// and the value are empty when the field wasn't found. applied is false if the field wasn't
+	// found or its value couldn't be converted. It's typically used to log the effective
+	// configuration, or to catch misnamed fields.
+	Audit func(fieldName string, sourceKey string, value string, applied bool)
+}
+
 // LoadPropertiesFile Loads the contents of a properties file into a configuration struct
 func LoadPropertiesFile(fileName string, optional bool, object interface{}, metaDataKey string) error {
+	return LoadPropertiesFileWithOptions(fileName, optional, object, metaDataKey, LoadOptions{})
+}
+
+// LoadPropertiesFileWithOptions Loads the contents of a properties file into a configuration struct using the specified options
+func LoadPropertiesFileWithOptions(fileName string, optional bool, object interface{}, metaDataKey string, options LoadOptions) error {
 	properties, err := ReadPropertiesFile(fileName, false)
 	if err != nil {
-		if optional {
-			return nil
+		if !optional {
+			return err
 		}
-		return err
+		// Still load defaults and check required fields
+		properties = map[string]string{}
 	}
 
-	return LoadProperties(properties, object, metaDataKey)
+	return LoadPropertiesWithOptions(properties, object, metaDataKey, options)
 }
 
 // LoadProperties Loads the contents of a map into a configuration struct
 func LoadProperties(properties map[string]string, object interface{}, metaDataKey string) error {
+	return LoadPropertiesWithOptions(properties, object, metaDataKey, LoadOptions{})
+}
+
+// LoadPropertiesWithOptions Loads the contents of a map into a configuration struct using the specified options
+func LoadPropertiesWithOptions(properties map[string]string, object interface{}, metaDataKey string, options LoadOptions) error {
+	values, keys := mapLookups(properties)
+	return commonLoad(values, keys, object, metaDataKey, options)
+}
+
+// mapLookups returns the helper functions to look up the keys of a map
+func mapLookups(properties map[string]string) (func(string) (string, bool), func() []string) {
 	var values = func(key string) (string, bool) {
 		value, ok := properties[key]
 		return value, ok
 	}
-	return commonLoad(values, object, metaDataKey)
+	var keys = func() []string {
+		result := make([]string, 0, len(properties))
+		for key := range properties {
+			result = append(result, key)
+		}
+		return result
+	}
+	return values, keys
 }
 
 // LoadEnvironment Loads a configuration struct from environment variables
 func LoadEnvironment(object interface{}, metaDataKey string) error {
+	return LoadEnvironmentWithOptions(object, metaDataKey, LoadOptions{})
+}
+
+// LoadEnvironmentWithOptions Loads a configuration struct from environment variables using the specified options
+func LoadEnvironmentWithOptions(object interface{}, metaDataKey string, options LoadOptions) error {
 	var values = func(key string) (string, bool) {
 		return os.LookupEnv(key)
 	}
-	return commonLoad(values, object, metaDataKey)
+	return commonLoad(values, environmentKeys, object, metaDataKey, options)
+}
+
+// LoadEnvironmentWithPrefix Loads a configuration struct from environment variables whose names are
+// the keys prefixed with prefix and an underscore, for example MYAPP_Port for the field Port with
+// the prefix MYAPP
+func LoadEnvironmentWithPrefix(prefix string, object interface{}, metaDataKey string) error {
+	return LoadEnvironmentWithPrefixAndOptions(prefix, object, metaDataKey, LoadOptions{})
+}
+
+// LoadEnvironmentWithPrefixAndOptions Loads a configuration struct from environment variables with a
+// prefix, like LoadEnvironmentWithPrefix, using the specified options. The options apply to the names
+// without the prefix, so with NormalizeKeys EDGE_DEVICE_ID is loaded into the field DeviceId with the
+// prefix EDGE.
+func LoadEnvironmentWithPrefixAndOptions(prefix string, object interface{}, metaDataKey string, options LoadOptions) error {
+	values, keys := prefixedEnvironment(prefix)
+	return commonLoad(values, keys, object, metaDataKey, options)
+}
+
+// prefixedEnvironment returns the helper functions to look up environment variables with a prefix,
+// which the keys returned don't include
+func prefixedEnvironment(prefix string) (func(string) (string, bool), func() []string) {
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+	var values = func(key string) (string, bool) {
+		return os.LookupEnv(prefix + key)
+	}
+	var keys = func() []string {
+		result := make([]string, 0)
+		for _, key := range environmentKeys() {
+			if strings.HasPrefix(key, prefix) {
+				result = append(result, strings.TrimPrefix(key, prefix))
+			}
+		}
+		return result
+	}
+	return values, keys
+}
+
+// Source is a source of values for LoadLayered, returned by PropertiesSource, FileSource or
+// EnvironmentSource. LoadLayered fails if passed the zero Source.
+type Source struct {
+	values func(string) (string, bool)
+	keys   func() []string
+	err    error
+}
+
+// PropertiesSource returns a Source of the values of a map
+func PropertiesSource(properties map[string]string) Source {
+	values, keys := mapLookups(properties)
+	return Source{values: values, keys: keys}
+}
+
+// FileSource returns a Source of the values of a properties file. If the file can't be read
+// LoadLayered fails, unless it's optional.
+func FileSource(fileName string, optional bool) Source {
+	properties, err := ReadPropertiesFile(fileName, optional)
+	if err != nil {
+		return Source{err: err}
+	}
+	return PropertiesSource(properties)
+}
+
+// EnvironmentSource returns a Source of the values of the environment variables
+func EnvironmentSource() Source {
+	return Source{values: os.LookupEnv, keys: environmentKeys}
+}
+
+// LoadLayered Loads a configuration struct from several sources, in order. A later source only
+// overrides the value of a field when it has a value for it, so for example a file can provide
+// the values that environment variables may override. Default values are only used for the
+// fields that none of the sources has a value for.
+func LoadLayered(object interface{}, metaDataKey string, sources ...Source) error {
+	for _, source := range sources {
+		if source.err != nil {
+			return source.err
+		}
+		if source.values == nil || source.keys == nil {
+			return errors.New("LoadLayered was called with a Source that wasn't returned by PropertiesSource, FileSource or EnvironmentSource")
+		}
+	}
+
+	var values = func(key string) (string, bool) {
+		for index := len(sources) - 1; index >= 0; index-- {
+			if value, ok := sources[index].values(key); ok {
+				return value, true
+			}
+		}
+		return "", false
+	}
+	var keys = func() []string {
+		found := make(map[string]bool)
+		result := make([]string, 0)
+		for _, source := range sources {
+			for _, key := range source.keys() {
+				if !found[key] {
+					found[key] = true
+					result = append(result, key)
+				}
+			}
+		}
+		return result
+	}
+	return commonLoad(values, keys, object, metaDataKey, LoadOptions{})
 }
 
-// commonLoad Loads values from a helper function into a configuration struct
-func commonLoad(values func(string) (string, bool), object interface{}, metaDataKey string) error {
+// environmentKeys returns the names of all of the environment variables
+func environmentKeys() []string {
+	environment := os.Environ()
+	result := make([]string, 0, len(environment))
+	for _, entry := range environment {
+		if index := strings.IndexByte(entry, '='); index > 0 {
+			result = append(result, entry[:index])
+		}
+	}
+	return result
+}
+
+// WatchInterval is the interval at which WatchPropertiesFile checks if the file changed
+var WatchInterval = 2 * time.Second
+
+// WatchPropertiesFile Watches a properties file, and loads it into a configuration struct each
+// time it changes, then calls onChange with the error of the load, if any. The file isn't loaded
+// initially. Changes are detected by polling the file's modification time and size, so files
+// saved by renaming a new file over them are handled, and while the file is missing (between
+// the rename and the create of some editors) nothing is done.
+//
+// The file is loaded into a copy of object, which is copied back into object only if the load
+// succeeded, so object is never left half loaded, and the values it got from other sources, such
+// as the environment, are kept unless the file sets them. The copy and the call to onChange are done on the
+// watching goroutine, so object must not be read concurrently without synchronization: either
+// object is only read in onChange, which typically copies it, under a lock, into the struct
+// used by the rest of the program, or the readers hold a lock that onChange also takes.
+// Calling stop stops watching.
+func WatchPropertiesFile(fileName string, object interface{}, metaDataKey string, onChange func(error)) (stop func(), err error) {
+	objectValue := reflect.ValueOf(object)
+	if objectValue.Kind() != reflect.Ptr || objectValue.Elem().Kind() != reflect.Struct {
+		return nil, errors.New("WatchPropertiesFile was called with an object that wasn't a pointer to a struct")
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func(modTime time.Time, size int64) {
+		ticker := time.NewTicker(WatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(fileName)
+			if err != nil || (info.ModTime().Equal(modTime) && info.Size() == size) {
+				continue
+			}
+			modTime, size = info.ModTime(), info.Size()
+
+			loaded := reflect.New(objectValue.Elem().Type())
+			loaded.Elem().Set(objectValue.Elem())
+			err = LoadPropertiesFile(fileName, false, loaded.Interface(), metaDataKey)
+			if err == nil {
+				objectValue.Elem().Set(loaded.Elem())
+			}
+			if onChange != nil {
+				onChange(err)
+			}
+		}
+	}(info.ModTime(), info.Size())
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }, nil
+}
+
+// LoadError holds all of the errors found while loading a configuration struct
+type LoadError struct {
+	Errors []error
+}
+
+func (e *LoadError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for index, err := range e.Errors {
+		messages[index] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// loader holds the state of loading values into a configuration struct
+type loader struct {
+	values      func(string) (string, bool)
+	keys        func() []string
+	metaDataKey string
+	missing     []string
+	errs        []error
+	used        map[string]bool
+	normalized  map[string]string
+	audit       func(string, string, string, bool)
+}
+
+// lookup Looks up the value of a key, recording that the key was used. When normalizing keys
+// a key that isn't found is looked up by its normalized form.
+func (l *loader) lookup(key string) (string, bool) {
+	value, ok := l.values(key)
+	if !ok && l.normalized != nil {
+		if original, found := l.normalized[normalizeKey(key)]; found {
+			key = original
+			value, ok = l.values(key)
+		}
+	}
+	if ok && l.used != nil {
+		l.used[key] = true
+	}
+	return value, ok
+}
+
+// commonLoad Loads values from a helper function into a configuration struct. Loading doesn't
+// stop at the first bad value, all of the fields that can be loaded are, and all of the
+// problems found are returned together in a LoadError.
+func commonLoad(values func(string) (string, bool), keys func() []string, object interface{}, metaDataKey string,
+	options LoadOptions) error {
 	objectType := reflect.TypeOf(object)
 	if objectType.Kind() != reflect.Ptr {
 		return errors.New("utility.commonLoad was called with non-pointer object")
@@ -50,120 +363,955 @@ func commonLoad(values func(string) (string, bool), object interface{}, metaData
 	if pointeeType.Kind() != reflect.Struct {
 		return errors.New("utility.commonLoad was called with an object that wasn't a pointer to a struct")
 	}
-	pointeeValue := reflect.ValueOf(object).Elem()
 
-	fieldCount := pointeeType.NumField()
+	l := loader{values: values, keys: keys, metaDataKey: metaDataKey, audit: options.Audit}
+	if options.NormalizeKeys {
+		l.normalized = normalizedKeys(keys())
+	}
+	l.loadStruct(reflect.ValueOf(object).Elem(), "")
+	if len(l.missing) != 0 {
+		l.errs = append(l.errs, errors.New("The required properties "+strings.Join(l.missing, ", ")+" were not found"))
+	}
+	if len(l.errs) != 0 {
+		return &LoadError{Errors: l.errs}
+	}
+	return nil
+}
+
+// normalizeKey Normalizes a key for matching, by uppercasing it and removing dashes and underscores
+func normalizeKey(key string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", "_", "").Replace(key))
+}
+
+// normalizedKeys Maps the normalized form of the keys to the first key, in sorted order, with that form
+func normalizedKeys(keys []string) map[string]string {
+	sort.Strings(keys)
+	result := make(map[string]string, len(keys))
+	for _, key := range keys {
+		normalized := normalizeKey(key)
+		if _, exists := result[normalized]; !exists {
+			result[normalized] = key
+		}
+	}
+	return result
+}
+
+// UnusedKeys Returns the keys of the properties, sorted, that wouldn't be loaded into any field
+// of the configuration struct, typically because they are misspelled. The object isn't modified.
+func UnusedKeys(properties map[string]string, object interface{}, metaDataKey string) []string {
+	return UnusedKeysWithOptions(properties, object, metaDataKey, LoadOptions{})
+}
+
+// UnusedKeysWithOptions Returns the keys of the properties that wouldn't be loaded into any field
+// of the configuration struct with the specified options, like UnusedKeys. With NormalizeKeys, the
+// keys matching a field only once normalized are used, except when another key normalized to the
+// same name is used instead.
+func UnusedKeysWithOptions(properties map[string]string, object interface{}, metaDataKey string, options LoadOptions) []string {
+	objectType := reflect.TypeOf(object)
+	if objectType.Kind() == reflect.Ptr {
+		objectType = objectType.Elem()
+	}
+	if objectType.Kind() != reflect.Struct {
+		return nil
+	}
+
+	values, keys := mapLookups(properties)
+	l := loader{values: values, keys: keys, metaDataKey: metaDataKey, used: make(map[string]bool)}
+	if options.NormalizeKeys {
+		l.normalized = normalizedKeys(keys())
+	}
+	l.loadStruct(reflect.New(objectType).Elem(), "")
+
+	result := make([]string, 0)
+	for key := range properties {
+		if !l.used[key] {
+			result = append(result, key)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// loadStruct Loads values into the fields of a struct. The keys of the fields are prefixed with
+// prefix. Nested struct fields are loaded recursively, with their keys prefixed by the field's
+// metadata tag, or its name, followed by a dot. Embedded structs are loaded without a prefix.
+// Fields tagged required:"true" that aren't found are added to the missing list, a default
+// value doesn't satisfy the requirement.
+func (l *loader) loadStruct(structValue reflect.Value, prefix string) {
+	structType := structValue.Type()
+	fieldCount := structType.NumField()
 	for fieldIndex := 0; fieldIndex < fieldCount; fieldIndex++ {
-		field := pointeeType.Field(fieldIndex)
-		value, ok := values(field.Name)
+		field := structType.Field(fieldIndex)
+		fieldValue := structValue.Field(fieldIndex)
+
+		if isNestedStruct(field) {
+			// The exported fields of unexported embedded structs can be set
+			if !fieldValue.CanSet() && !field.Anonymous {
+				continue
+			}
+			nestedPrefix := prefix
+			if !field.Anonymous {
+				name, ok := field.Tag.Lookup(l.metaDataKey)
+				if !ok {
+					name = field.Name
+				}
+				nestedPrefix = prefix + name + "."
+			}
+			l.loadStruct(fieldValue, nestedPrefix)
+			continue
+		}
+
+		if pattern, ok := field.Tag.Lookup("match"); ok {
+			if fieldValue.CanSet() {
+				l.loadMatches(fieldValue, field, pattern)
+			}
+			continue
+		}
+
+		if name, ok := field.Tag.Lookup("indexed"); ok {
+			if fieldValue.CanSet() {
+				l.loadIndexed(fieldValue, field, prefix+name)
+			}
+			continue
+		}
+
+		key := prefix + field.Name
+		value, ok := l.lookup(key)
 		if !ok {
 			var tagValue string
-			tagValue, ok = field.Tag.Lookup(metaDataKey)
+			tagValue, ok = field.Tag.Lookup(l.metaDataKey)
 			if ok {
-				value, ok = values(tagValue)
+				key = prefix + tagValue
+				value, ok = l.lookup(key)
+			}
+		}
+		sourceKey := key
+		if !ok {
+			if required, _ := strconv.ParseBool(field.Tag.Get("required")); required {
+				l.missing = append(l.missing, key)
 			}
+			value, ok = field.Tag.Lookup(DefaultValueKey)
+			key = prefix + field.Name
+			sourceKey = ""
+		}
+
+		if ok && fieldValue.CanSet() {
+			l.audited(field.Name, sourceKey, value, l.setValue(fieldValue, field, key, value))
+		} else if fieldValue.CanSet() {
+			l.audited(field.Name, "", "", false)
 		}
+	}
+}
 
-		if fieldValue := pointeeValue.Field(fieldIndex); ok && fieldValue.CanSet() {
-			switch field.Type.Kind() {
-			case reflect.Bool:
-				var boolValue bool
-				switch strings.ToLower(value) {
-				case "1":
-					boolValue = true
+// isNestedStruct checks if a field is a struct whose fields should be loaded individually,
+// rather than a struct loaded from a single value, like time.Time, the types implementing
+// encoding.TextUnmarshaler and the types with a registered converter
+func isNestedStruct(field reflect.StructField) bool {
+	if field.Type.Kind() != reflect.Struct || field.Type == timeType ||
+		reflect.PtrTo(field.Type).Implements(textUnmarshalerType) {
+		return false
+	}
+	_, converted := converter(field.Type)
+	return !converted
+}
 
-				case "true":
-					boolValue = true
+// loadMatches Loads all of the values whose keys match a regular expression into a map field.
+// If the field has a strip tag, that prefix is removed from the keys stored in the map.
+func (l *loader) loadMatches(fieldValue reflect.Value, field reflect.StructField, pattern string) {
+	if field.Type.Kind() != reflect.Map || field.Type.Key().Kind() != reflect.String {
+		l.errs = append(l.errs, fmt.Errorf("The field %s has a match tag but isn't a map with string keys", field.Name))
+		return
+	}
+	matcher, err := regexp.Compile(pattern)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("The match tag of the field %s is invalid. Error: %s", field.Name, err))
+		return
+	}
+	strip := field.Tag.Get("strip")
 
-				case "t":
-					boolValue = true
-				}
-				fieldValue.SetBool(boolValue)
-
-			case reflect.Int:
-				fallthrough
-			case reflect.Int8:
-				fallthrough
-			case reflect.Int16:
-				fallthrough
-			case reflect.Int32:
-				fallthrough
-			case reflect.Int64:
-				var intValue int64
-				if 0 != len(value) {
-					_, err := fmt.Sscanf(value, "%d", &intValue)
-					if err != nil {
-						return err
-					}
+	result := reflect.MakeMap(field.Type)
+	for _, key := range l.keys() {
+		if !matcher.MatchString(key) {
+			continue
+		}
+		value, _ := l.lookup(key)
+		element := reflect.New(field.Type.Elem()).Elem()
+		applied := l.setValue(element, field, key, value)
+		l.audited(field.Name, key, value, applied)
+		if applied {
+			result.SetMapIndex(reflect.ValueOf(strings.TrimPrefix(key, strip)).Convert(field.Type.Key()), element)
+		}
+	}
+	fieldValue.Set(result)
+}
+
+// loadIndexed Loads the values of the keys name_0, name_1, ... into a slice field. The keys
+// are read until the first missing index, so any keys after a gap are ignored. If name_0
+// isn't present the field is left unchanged.
+func (l *loader) loadIndexed(fieldValue reflect.Value, field reflect.StructField, name string) {
+	if field.Type.Kind() != reflect.Slice {
+		l.errs = append(l.errs, fmt.Errorf("The field %s has an indexed tag but isn't a slice", field.Name))
+		return
+	}
+
+	result := reflect.MakeSlice(field.Type, 0, 0)
+	for index := 0; ; index++ {
+		key := fmt.Sprintf("%s_%d", name, index)
+		value, ok := l.lookup(key)
+		if !ok {
+			break
+		}
+		element := reflect.New(field.Type.Elem()).Elem()
+		applied := l.setValue(element, field, key, value)
+		l.audited(field.Name, key, value, applied)
+		if applied {
+			result = reflect.Append(result, element)
+		}
+	}
+	if result.Len() > 0 {
+		fieldValue.Set(result)
+	}
+}
+
+// audited Reports the loading of a field to the audit function, if any
+func (l *loader) audited(fieldName string, sourceKey string, value string, applied bool) {
+	if l.audit != nil {
+		l.audit(fieldName, sourceKey, value, applied)
+	}
+}
+
+// setValue Sets the value of a key into a field, recording any error. Returns true if the value was set.
+func (l *loader) setValue(fieldValue reflect.Value, field reflect.StructField, key string, value string) bool {
+	if err := setValue(fieldValue, field, value); err != nil {
+		l.errs = append(l.errs, fmt.Errorf("Failed to load the value '%s' of %s. Error: %s", value, key, err))
+		return false
+	}
+	if err := validate(fieldValue, field); err != nil {
+		l.errs = append(l.errs, fmt.Errorf("The value '%s' of %s is invalid. Error: %s", value, key, err))
+		return false
+	}
+	return true
+}
+
+// validate Checks a loaded value against the validation tags of its field: min and max for numbers
+// and durations, maxlen for strings and oneof, a comma separated list of the allowed values
+func validate(fieldValue reflect.Value, field reflect.StructField) error {
+	if fieldValue.Kind() == reflect.Ptr {
+		if fieldValue.IsNil() {
+			return nil
+		}
+		fieldValue = fieldValue.Elem()
+	}
+
+	for _, bound := range []string{"min", "max"} {
+		limit, ok := field.Tag.Lookup(bound)
+		if !ok {
+			continue
+		}
+		comparison, err := compareToLimit(fieldValue, strings.TrimSpace(limit))
+		if err != nil {
+			return fmt.Errorf("invalid %s tag '%s': %s", bound, limit, err)
+		}
+		if bound == "min" && comparison < 0 {
+			return fmt.Errorf("less than the minimum %s", limit)
+		}
+		if bound == "max" && comparison > 0 {
+			return fmt.Errorf("greater than the maximum %s", limit)
+		}
+	}
+
+	if maxLength, ok := field.Tag.Lookup("maxlen"); ok && fieldValue.Kind() == reflect.String {
+		length, err := strconv.Atoi(strings.TrimSpace(maxLength))
+		if err != nil {
+			return fmt.Errorf("invalid maxlen tag '%s': %s", maxLength, err)
+		}
+		if utf8.RuneCountInString(fieldValue.String()) > length {
+			return fmt.Errorf("longer than the maximum length %d", length)
+		}
+	}
+
+	if allowed, ok := field.Tag.Lookup("oneof"); ok {
+		formatted := fmt.Sprint(fieldValue.Interface())
+		for _, choice := range strings.Split(allowed, ",") {
+			if strings.TrimSpace(choice) == formatted {
+				return nil
+			}
+		}
+		return fmt.Errorf("not one of %s", allowed)
+	}
+	return nil
+}
+
+// compareToLimit Compares a number or duration to a limit, returning -1, 0 or 1
+func compareToLimit(fieldValue reflect.Value, limit string) (int, error) {
+	if fieldValue.Type() == durationType {
+		limitValue, err := time.ParseDuration(limit)
+		if err != nil {
+			return 0, err
+		}
+		return compareFloats(float64(fieldValue.Int()), float64(limitValue)), nil
+	}
+
+	switch fieldValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		limitValue, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		value := fieldValue.Int()
+		if value < limitValue {
+			return -1, nil
+		} else if value > limitValue {
+			return 1, nil
+		}
+		return 0, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		limitValue, err := strconv.ParseUint(limit, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		value := fieldValue.Uint()
+		if value < limitValue {
+			return -1, nil
+		} else if value > limitValue {
+			return 1, nil
+		}
+		return 0, nil
+
+	case reflect.Float32, reflect.Float64:
+		limitValue, err := strconv.ParseFloat(limit, 64)
+		if err != nil {
+			return 0, err
+		}
+		return compareFloats(fieldValue.Float(), limitValue), nil
+	}
+	return 0, errors.New("only numbers and durations can be bounded")
+}
+
+func compareFloats(value float64, limit float64) int {
+	if value < limit {
+		return -1
+	} else if value > limit {
+		return 1
+	}
+	return 0
+}
+
+// setValue Converts a value to the type of the field and sets it. A pointer field is set to
+// a newly allocated value, so a pointer field stays nil when its key isn't found, which
+// distinguishes an unset value from a zero value.
+func setValue(fieldValue reflect.Value, field reflect.StructField, value string) error {
+	if fieldValue.Kind() == reflect.Ptr {
+		pointee := reflect.New(fieldValue.Type().Elem())
+		if err := setValue(pointee.Elem(), field, value); err != nil {
+			return err
+		}
+		fieldValue.Set(pointee)
+		return nil
+	}
+
+	// Stray whitespace is ignored in everything but string values
+	if fieldValue.Kind() != reflect.String {
+		value = strings.TrimSpace(value)
+	}
+
+	if convert, ok := converter(fieldValue.Type()); ok {
+		converted, err := convert(value)
+		if err != nil {
+			return err
+		}
+		convertedValue := reflect.ValueOf(converted)
+		switch {
+		case !convertedValue.IsValid():
+			fieldValue.Set(reflect.Zero(fieldValue.Type()))
+		case convertedValue.Type().AssignableTo(fieldValue.Type()):
+			fieldValue.Set(convertedValue)
+		case convertedValue.Type().ConvertibleTo(fieldValue.Type()):
+			fieldValue.Set(convertedValue.Convert(fieldValue.Type()))
+		default:
+			return fmt.Errorf("the converter of %s returned a %s", fieldValue.Type(), convertedValue.Type())
+		}
+		return nil
+	}
+
+	if fieldValue.Type() == timeType {
+		var timeValue time.Time
+		if 0 != len(value) {
+			layout, ok := field.Tag.Lookup("timeformat")
+			if !ok {
+				layout = time.RFC3339
+			}
+			var err error
+			timeValue, err = time.Parse(layout, value)
+			if err != nil {
+				return err
+			}
+		}
+		fieldValue.Set(reflect.ValueOf(timeValue))
+		return nil
+	}
+
+	// Durations must have a unit (e.g. 30s, 5m, 100ms), plain numbers other than 0 are rejected
+	if fieldValue.Type() == durationType {
+		var durationValue time.Duration
+		if 0 != len(value) {
+			var err error
+			durationValue, err = time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+		}
+		fieldValue.SetInt(int64(durationValue))
+		return nil
+	}
+
+	// Types implementing encoding.TextUnmarshaler, such as net.IP, parse themselves
+	if fieldValue.CanAddr() && fieldValue.Addr().Type().Implements(textUnmarshalerType) {
+		return fieldValue.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	switch fieldValue.Kind() {
+	case reflect.Bool:
+		var boolValue bool
+		switch strings.ToLower(value) {
+		case "1", "true", "t", "yes", "y", "on", "enable", "enabled":
+			boolValue = true
+
+		case "", "0", "false", "f", "no", "n", "off", "disable", "disabled":
+			boolValue = false
+
+		default:
+			return errors.New("not a boolean value")
+		}
+		fieldValue.SetBool(boolValue)
+
+	case reflect.Int:
+		fallthrough
+	case reflect.Int8:
+		fallthrough
+	case reflect.Int16:
+		fallthrough
+	case reflect.Int32:
+		fallthrough
+	case reflect.Int64:
+		var intValue int64
+		if 0 != len(value) {
+			_, err := fmt.Sscanf(value, "%d", &intValue)
+			if err != nil {
+				return err
+			}
+		}
+		fieldValue.SetInt(intValue)
+
+	case reflect.Uint:
+		fallthrough
+	case reflect.Uint8:
+		fallthrough
+	case reflect.Uint16:
+		fallthrough
+	case reflect.Uint32:
+		fallthrough
+	case reflect.Uint64:
+		var uintValue uint64
+		if 0 != len(value) {
+			_, err := fmt.Sscanf(value, "%d", &uintValue)
+			if err != nil {
+				return err
+			}
+		}
+		fieldValue.SetUint(uintValue)
+
+	case reflect.Float32:
+		fallthrough
+	case reflect.Float64:
+		var floatValue float64
+		if 0 != len(value) {
+			var err error
+			floatValue, err = strconv.ParseFloat(value, fieldValue.Type().Bits())
+			if err != nil {
+				return err
+			}
+		}
+		fieldValue.SetFloat(floatValue)
+
+	case reflect.String:
+		fieldValue.SetString(value)
+
+	case reflect.Slice:
+		if fieldValue.Type().Elem().Kind() == reflect.Uint8 {
+			bytesValue, err := decodeBytes(value, field.Tag.Get("encoding"))
+			if err != nil {
+				return err
+			}
+			fieldValue.SetBytes(bytesValue)
+		}
+	}
+
+	return nil
+}
+
+// decodeBytes decodes a value destined for a []byte field using the encoding
+// named by the field's encoding tag, base64 by default
+func decodeBytes(value string, encoding string) ([]byte, error) {
+	switch strings.ToLower(encoding) {
+	case "", "base64":
+		return base64.StdEncoding.DecodeString(value)
+	case "hex":
+		return hex.DecodeString(value)
+	}
+	return nil, errors.New("unsupported encoding '" + encoding + "'")
+}
+
+// WritePropertiesFile Writes a configuration struct to a properties file, as Key value lines.
+// The key is the field's metadata tag if it has one, otherwise the field's name. Since the
+// configuration may hold secrets, the file is only readable by its owner.
+func WritePropertiesFile(fileName string, object interface{}, metaDataKey string) error {
+	objectValue := reflect.ValueOf(object)
+	if objectValue.Kind() == reflect.Ptr {
+		objectValue = objectValue.Elem()
+	}
+	if objectValue.Kind() != reflect.Struct {
+		return errors.New("utility.WritePropertiesFile was called with an object that wasn't a struct or a pointer to a struct")
+	}
+
+	var b bytes.Buffer
+	writeStruct(&b, objectValue, "", metaDataKey)
+	return ioutil.WriteFile(fileName, b.Bytes(), 0600)
+}
+
+// writeStruct Writes the fields of a struct as Key value lines, prefixing nested structs'
+// keys the same way as loadStruct
+func writeStruct(b *bytes.Buffer, structValue reflect.Value, prefix string, metaDataKey string) {
+	structType := structValue.Type()
+	fieldCount := structType.NumField()
+	for fieldIndex := 0; fieldIndex < fieldCount; fieldIndex++ {
+		field := structType.Field(fieldIndex)
+		fieldValue := structValue.Field(fieldIndex)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		key, ok := field.Tag.Lookup(metaDataKey)
+		if !ok {
+			key = field.Name
+		}
+
+		if isNestedStruct(field) {
+			if field.Anonymous {
+				writeStruct(b, fieldValue, prefix, metaDataKey)
+			} else {
+				writeStruct(b, fieldValue, prefix+key+".", metaDataKey)
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if _, ok := field.Tag.Lookup("match"); ok {
+			if fieldValue.Kind() != reflect.Map {
+				continue
+			}
+			strip := field.Tag.Get("strip")
+			mapKeys := fieldValue.MapKeys()
+			sort.Slice(mapKeys, func(i, j int) bool { return mapKeys[i].String() < mapKeys[j].String() })
+			for _, mapKey := range mapKeys {
+				if value, ok := formatValue(fieldValue.MapIndex(mapKey), field); ok {
+					writeProperty(b, strip+mapKey.String(), value)
 				}
-				fieldValue.SetInt(intValue)
-
-			case reflect.Uint:
-				fallthrough
-			case reflect.Uint8:
-				fallthrough
-			case reflect.Uint16:
-				fallthrough
-			case reflect.Uint32:
-				fallthrough
-			case reflect.Uint64:
-				var uintValue uint64
-				if 0 != len(value) {
-					_, err := fmt.Sscanf(value, "%d", &uintValue)
-					if err != nil {
-						return err
-					}
+			}
+			continue
+		}
+
+		if name, ok := field.Tag.Lookup("indexed"); ok {
+			if fieldValue.Kind() != reflect.Slice {
+				continue
+			}
+			for index := 0; index < fieldValue.Len(); index++ {
+				if value, ok := formatValue(fieldValue.Index(index), field); ok {
+					writeProperty(b, fmt.Sprintf("%s%s_%d", prefix, name, index), value)
 				}
-				fieldValue.SetUint(uintValue)
+			}
+			continue
+		}
 
-			case reflect.String:
-				fieldValue.SetString(value)
+		if value, ok := formatValue(fieldValue, field); ok {
+			writeProperty(b, prefix+key, value)
+		}
+	}
+}
+
+// writeProperty Writes a Key value line. Values starting with a separator are written after
+// an explicit = separator, otherwise the reader would take their first character as the separator.
+func writeProperty(b *bytes.Buffer, key string, value string) {
+	if strings.HasPrefix(value, "=") || strings.HasPrefix(value, ":") {
+		fmt.Fprintf(b, "%s = %s\n", key, value)
+		return
+	}
+	fmt.Fprintf(b, "%s %s\n", key, value)
+}
+
+// formatValue Formats the value of a field so that setValue can parse it. Returns false for
+// values of kinds that setValue doesn't support.
+func formatValue(fieldValue reflect.Value, field reflect.StructField) (string, bool) {
+	if fieldValue.Kind() == reflect.Ptr {
+		if fieldValue.IsNil() {
+			return "", false
+		}
+		return formatValue(fieldValue.Elem(), field)
+	}
+	if _, ok := converter(fieldValue.Type()); ok {
+		if fieldValue.Type().Implements(stringerType) {
+			return fieldValue.Interface().(fmt.Stringer).String(), true
+		}
+		// Such as url.URL, whose String method has a pointer receiver
+		if fieldValue.CanAddr() && fieldValue.Addr().Type().Implements(stringerType) {
+			return fieldValue.Addr().Interface().(fmt.Stringer).String(), true
+		}
+	}
+	if fieldValue.Type() != timeType && fieldValue.Type().Implements(textMarshalerType) {
+		text, err := fieldValue.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err == nil
+	}
+	if fieldValue.Type() == timeType {
+		layout, ok := field.Tag.Lookup("timeformat")
+		if !ok {
+			layout = time.RFC3339
+		}
+		return fieldValue.Interface().(time.Time).Format(layout), true
+	}
+	if fieldValue.Type() == durationType {
+		return time.Duration(fieldValue.Int()).String(), true
+	}
+
+	switch fieldValue.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(fieldValue.Bool()), true
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fieldValue.Int(), 10), true
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(fieldValue.Uint(), 10), true
+
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(fieldValue.Float(), 'g', -1, fieldValue.Type().Bits()), true
+
+	case reflect.String:
+		return fieldValue.String(), true
+
+	case reflect.Slice:
+		if fieldValue.Type().Elem().Kind() == reflect.Uint8 {
+			if strings.EqualFold(field.Tag.Get("encoding"), "hex") {
+				return hex.EncodeToString(fieldValue.Bytes()), true
 			}
+			return base64.StdEncoding.EncodeToString(fieldValue.Bytes()), true
 		}
 	}
+	return "", false
+}
 
-	return nil
+// DuplicatePolicy what to do when a key is defined more than once
+type DuplicatePolicy int
+
+// Duplicate key policies
+const (
+	// DuplicateError fails reading the properties
+	DuplicateError DuplicatePolicy = iota
+	// DuplicateLastWins keeps the last value, so later definitions override earlier ones
+	DuplicateLastWins
+	// DuplicateFirstWins keeps the first value, ignoring later definitions
+	DuplicateFirstWins
+)
+
+// ReadOptions options controlling how a properties file is parsed
+type ReadOptions struct {
+	// Sections enables INI style [section] headers. Keys following a header are
+	// namespaced as section.key
+	Sections bool
+
+	// DefaultSection is the section applied to keys that appear before any header.
+	// If empty, such keys are not namespaced
+	DefaultSection string
+
+	// ExpandEnvironment expands ${VAR} and $VAR references to environment variables in values.
+	// $$ expands to a literal $
+	ExpandEnvironment bool
+
+	// StrictExpansion leaves references to undefined environment variables intact (as ${VAR}),
+	// rather than expanding them to an empty string
+	StrictExpansion bool
+
+	// InlineComments removes comments at the end of values, starting with a # preceded by
+	// whitespace, so that a # without whitespace before it, like in URL fragments, is kept
+	InlineComments bool
+
+	// QuotedValues unquotes values enclosed in double quotes, keeping their leading and trailing
+	// whitespace and interpreting the Go escape sequences in them, such as \n, \t, \" and \\.
+	// Values that don't start with a double quote are unchanged.
+	QuotedValues bool
+
+	// LineContinuation joins a line ending with a backslash with the next line, without the
+	// backslash and the leading whitespace of the next line. A line ending with an escaped
+	// backslash (\\) isn't continued. Comment lines are never continued, but the lines
+	// continuing a property are part of its value even if they start with a #.
+	LineContinuation bool
+
+	// Duplicates is the policy for keys defined more than once, DuplicateError by default
+	Duplicates DuplicatePolicy
+
+	// Includes processes @include path lines, reading the properties of the file at path,
+	// relative to the directory of the including file, as if they were at the place of the line.
+	// The keys of the included file are defined in the order they are read, subject to the
+	// Duplicates policy, and aren't affected by the section of the including file. Includes can
+	// be nested up to maxIncludeDepth deep, and a file including itself, directly or not, is
+	// an error.
+	Includes bool
 }
 
+// maxIncludeDepth is the maximum nesting of included files
+const maxIncludeDepth = 16
+
 // ReadPropertiesFile Reads a properties file into a map[string]string
 func ReadPropertiesFile(fileName string, optional bool) (map[string]string, error) {
-	result := make(map[string]string)
+	return ReadPropertiesFileWithOptions(fileName, optional, ReadOptions{})
+}
 
+// ReadPropertiesFileWithOptions Reads a properties file into a map[string]string using the specified options
+func ReadPropertiesFileWithOptions(fileName string, optional bool, options ReadOptions) (map[string]string, error) {
 	rdr, err := os.Open(fileName)
 	if err != nil {
 		if optional {
-			return result, nil
+			return make(map[string]string), nil
 		}
 		return nil, err
 	}
 	defer rdr.Close()
 
-	fileScanner := bufio.NewScanner(rdr)
+	result := make(map[string]string)
+	if err := readProperties(rdr, fileName, options, result, nil); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ReadProperties Reads properties from a reader into a map[string]string
+func ReadProperties(r io.Reader) (map[string]string, error) {
+	return ReadPropertiesWithOptions(r, ReadOptions{})
+}
+
+// ReadPropertiesWithOptions Reads properties from a reader into a map[string]string using the specified options
+func ReadPropertiesWithOptions(r io.Reader, options ReadOptions) (map[string]string, error) {
+	result := make(map[string]string)
+	if err := readProperties(r, "", options, result, nil); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// readProperties parses properties into result. fileName is the name of the file being read,
+// empty for a reader, and includes is the chain of absolute names of the files including it.
+func readProperties(r io.Reader, fileName string, options ReadOptions, result map[string]string, includes []string) error {
+	source := ""
+	if fileName != "" {
+		source = " in the file '" + fileName + "'"
+	}
+	section := options.DefaultSection
+
+	fileScanner := bufio.NewScanner(r)
 	for fileScanner.Scan() {
 		line := fileScanner.Text()
+		if options.LineContinuation && len(line) > 0 && line[0] != '#' {
+			for continued(line) {
+				line = line[:len(line)-1]
+				if !fileScanner.Scan() {
+					break
+				}
+				line += strings.TrimLeftFunc(fileScanner.Text(), unicode.IsSpace)
+			}
+		}
 		if len(line) > 0 && line[0] != '#' {
-			parts := strings.Fields(line)
-			var value []string
-			if len(parts) >= 1 {
-				key := parts[0]
-
-				if len(parts) > 1 {
-					value = parts[1:]
-				} else {
-					value = []string{""}
+			if options.Sections {
+				if name, ok := sectionHeader(line); ok {
+					section = name
+					continue
+				}
+			}
+
+			if options.Includes {
+				if path, ok := includeDirective(line); ok {
+					if err := includeProperties(path, fileName, options, result, includes); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+
+			key, value, ok := splitKeyValue(line)
+			if ok {
+				if options.QuotedValues && strings.HasPrefix(value, "\"") {
+					unquoted, rest, err := unquoteValue(value)
+					if err != nil {
+						return errors.New("The value of the property '" + key + "' isn't a valid quoted string" + source)
+					}
+					if rest != "" && !(options.InlineComments && rest[0] == '#') {
+						return errors.New("The value of the property '" + key + "' has text after the closing quote" + source)
+					}
+					value = unquoted
+				} else if options.InlineComments {
+					value = stripInlineComment(value)
+				}
+				if section != "" {
+					key = section + "." + key
 				}
 
 				_, ok := result[key]
 				if ok {
-					return nil, errors.New("The property '" + key + "' is found twice in the file '" + fileName + "'")
+					switch options.Duplicates {
+					case DuplicateFirstWins:
+						continue
+					case DuplicateLastWins:
+					default:
+						return errors.New("The property '" + key + "' is found twice" + source)
+					}
+				}
+				result[key] = value
+				if options.ExpandEnvironment {
+					result[key] = expandEnvironment(result[key], options.StrictExpansion)
 				}
-				result[key] = strings.Join(value, "")
 			}
 		}
 	}
-	if err := fileScanner.Err(); err != nil {
-		return nil, err
+	return fileScanner.Err()
+}
+
+// includeDirective checks if a line is an @include path line and if so returns the path
+func includeDirective(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "@include") {
+		return "", false
 	}
+	path := strings.TrimPrefix(trimmed, "@include")
+	if path == "" || !unicode.IsSpace(rune(path[0])) {
+		return "", false
+	}
+	return strings.TrimSpace(path), true
+}
 
-	return result, nil
+// includeProperties reads the properties of an included file into result
+func includeProperties(path string, includingFile string, options ReadOptions, result map[string]string, includes []string) error {
+	if !filepath.IsAbs(path) && includingFile != "" {
+		path = filepath.Join(filepath.Dir(includingFile), path)
+	}
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	chain := includes
+	if includingFile != "" && len(includes) == 0 {
+		if including, err := filepath.Abs(includingFile); err == nil {
+			chain = []string{including}
+		}
+	}
+	if len(chain) >= maxIncludeDepth {
+		return errors.New("The include of '" + path + "' is nested too deep")
+	}
+	for _, included := range chain {
+		if included == absolutePath {
+			return errors.New("The file '" + path + "' includes itself")
+		}
+	}
+
+	rdr, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	return readProperties(rdr, path, options, result, append(chain[:len(chain):len(chain)], absolutePath))
+}
+
+// splitKeyValue splits a line into a key and a value. The key ends at the first '=', ':' or
+// whitespace, optionally followed by '=' or ':' after the whitespace. The value is the rest
+// of the line with leading and trailing whitespace trimmed, internal spacing is kept.
+func splitKeyValue(line string) (string, string, bool) {
+	line = strings.TrimLeftFunc(line, unicode.IsSpace)
+	if len(line) == 0 {
+		return "", "", false
+	}
+
+	end := strings.IndexAny(line, "=: \t")
+	if end == -1 {
+		return line, "", true
+	}
+	key := line[:end]
+	if line[end] == '=' || line[end] == ':' {
+		return key, strings.TrimSpace(line[end+1:]), true
+	}
+
+	rest := strings.TrimLeftFunc(line[end:], unicode.IsSpace)
+	if len(rest) > 0 && (rest[0] == '=' || rest[0] == ':') {
+		return key, strings.TrimSpace(rest[1:]), true
+	}
+	return key, strings.TrimSpace(rest), true
+}
+
+// continued checks if a line ends with an odd number of backslashes, the last one not being escaped
+func continued(line string) bool {
+	count := 0
+	for index := len(line) - 1; index >= 0 && line[index] == '\\'; index-- {
+		count++
+	}
+	return count%2 == 1
+}
+
+// unquoteValue unquotes a value starting with a double quote, and returns the rest of the
+// value after the closing quote
+func unquoteValue(value string) (string, string, error) {
+	for index := 1; index < len(value); index++ {
+		switch value[index] {
+		case '\\':
+			index++
+		case '"':
+			unquoted, err := strconv.Unquote(value[:index+1])
+			return unquoted, strings.TrimSpace(value[index+1:]), err
+		}
+	}
+	return "", "", errors.New("missing closing quote")
+}
+
+// stripInlineComment removes a comment, starting with a # at the start of the value or after
+// whitespace, from the end of a value
+func stripInlineComment(value string) string {
+	for index := 0; index < len(value); index++ {
+		if value[index] == '#' && (index == 0 || value[index-1] == ' ' || value[index-1] == '\t') {
+			return strings.TrimSpace(value[:index])
+		}
+	}
+	return value
+}
+
+// expandEnvironment expands references to environment variables in a value
+func expandEnvironment(value string, strict bool) string {
+	return os.Expand(value, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		expanded, ok := os.LookupEnv(name)
+		if !ok && strict {
+			return "${" + name + "}"
+		}
+		return expanded
+	})
+}
+
+// sectionHeader checks if a line is a [section] header and if so returns the section name
+func sectionHeader(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if len(trimmed) < 2 || trimmed[0] != '[' || trimmed[len(trimmed)-1] != ']' {
+		return "", false
+	}
+	return strings.TrimSpace(trimmed[1 : len(trimmed)-1]), true
 }
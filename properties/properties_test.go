@@ -0,0 +1,734 @@
+package properties
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	RegisterConverter(reflect.TypeOf(url.URL{}), func(value string) (interface{}, error) {
+		parsed, err := url.Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		return *parsed, nil
+	})
+}
+
+type endpoint struct {
+	Host string `config:"host"`
+	Port int    `config:"port" default:"80"`
+}
+
+type converted struct {
+	Server   url.URL  `config:"server"`
+	Fallback url.URL  `config:"fallback" default:"http://localhost:8080"`
+	Endpoint endpoint `config:"endpoint"`
+}
+
+func TestConvertedStruct(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties map[string]string
+		server     string
+		fallback   string
+		endpoint   endpoint
+		err        string
+	}{
+		{
+			name:       "converted",
+			properties: map[string]string{"server": "https://example.com:8443/api"},
+			server:     "https://example.com:8443/api",
+			fallback:   "http://localhost:8080",
+			endpoint:   endpoint{Port: 80},
+		},
+		{
+			name:       "nested",
+			properties: map[string]string{"endpoint.host": "edge", "endpoint.port": "9443"},
+			fallback:   "http://localhost:8080",
+			endpoint:   endpoint{Host: "edge", Port: 9443},
+		},
+		{
+			name:       "ignored fields of the converted struct",
+			properties: map[string]string{"server.Host": "example.com"},
+			fallback:   "http://localhost:8080",
+			endpoint:   endpoint{Port: 80},
+		},
+		{
+			name:       "invalid",
+			properties: map[string]string{"server": "http://[::1"},
+			err:        "server",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var object converted
+			err := LoadProperties(test.properties, &object, "config")
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Expected an error about %s, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if server := object.Server.String(); server != test.server {
+				t.Errorf("Server is %q, expected %q", server, test.server)
+			}
+			if fallback := object.Fallback.String(); fallback != test.fallback {
+				t.Errorf("Fallback is %q, expected %q", fallback, test.fallback)
+			}
+			if object.Endpoint != test.endpoint {
+				t.Errorf("Endpoint is %+v, expected %+v", object.Endpoint, test.endpoint)
+			}
+		})
+	}
+}
+
+func TestWriteConvertedStruct(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "converted.properties")
+	var object converted
+	if err := LoadProperties(map[string]string{"server": "https://example.com/api"}, &object, "config"); err != nil {
+		t.Fatal(err)
+	}
+	if err := WritePropertiesFile(fileName, &object, "config"); err != nil {
+		t.Fatal(err)
+	}
+	var loaded converted
+	if err := LoadPropertiesFile(fileName, false, &loaded, "config"); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Server.String() != object.Server.String() || loaded.Fallback.String() != object.Fallback.String() {
+		t.Errorf("Loaded %s and %s, expected %s and %s", loaded.Server.String(), loaded.Fallback.String(),
+			object.Server.String(), object.Fallback.String())
+	}
+}
+
+type watched struct {
+	Level  int    `config:"level"`
+	Device string `config:"device"`
+	Mode   string `config:"mode" default:"auto"`
+}
+
+func TestWatchPropertiesFile(t *testing.T) {
+	savedInterval := WatchInterval
+	WatchInterval = 10 * time.Millisecond
+	defer func() { WatchInterval = savedInterval }()
+
+	tests := []struct {
+		name     string
+		changed  string
+		expected watched
+		err      bool
+	}{
+		{
+			name:     "value from another source kept",
+			changed:  "level = 2\n",
+			expected: watched{Level: 2, Device: "from-environment", Mode: "auto"},
+		},
+		{
+			name:     "value from another source overridden",
+			changed:  "level = 3\ndevice = from-file\nmode = manual\n",
+			expected: watched{Level: 3, Device: "from-file", Mode: "manual"},
+		},
+		{
+			name:     "invalid",
+			changed:  "level = high\n",
+			expected: watched{Level: 1, Device: "from-environment", Mode: "auto"},
+			err:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "watched.properties")
+			if err := ioutil.WriteFile(fileName, []byte("# initial values\nlevel = 1\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			object := watched{Device: "from-environment"}
+			if err := LoadPropertiesFile(fileName, false, &object, "config"); err != nil {
+				t.Fatal(err)
+			}
+
+			changes := make(chan error, 1)
+			stop, err := WatchPropertiesFile(fileName, &object, "config", func(err error) {
+				select {
+				case changes <- err:
+				default:
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stop()
+			// Replaced by a rename, so that the file isn't loaded half written
+			if err := ioutil.WriteFile(fileName+".new", []byte(test.changed), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(fileName+".new", fileName); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case err = <-changes:
+			case <-time.After(5 * time.Second):
+				t.Fatal("The change wasn't detected")
+			}
+			stop()
+			if (err != nil) != test.err {
+				t.Errorf("Unexpected error %v", err)
+			}
+			if object != test.expected {
+				t.Errorf("Loaded %+v, expected %+v", object, test.expected)
+			}
+		})
+	}
+}
+
+type device struct {
+	DeviceId string
+	Port     int `config:"port"`
+}
+
+func TestLoadEnvironmentWithPrefix(t *testing.T) {
+	t.Setenv("EDGE_DEVICE_ID", "edge-1")
+	t.Setenv("EDGE_port", "8443")
+	t.Setenv("DEVICE_ID", "unprefixed")
+
+	tests := []struct {
+		name     string
+		prefix   string
+		options  LoadOptions
+		expected device
+	}{
+		{name: "exact names", prefix: "EDGE", expected: device{Port: 8443}},
+		{name: "normalized names", prefix: "EDGE", options: LoadOptions{NormalizeKeys: true},
+			expected: device{DeviceId: "edge-1", Port: 8443}},
+		{name: "prefix with underscore", prefix: "EDGE_", options: LoadOptions{NormalizeKeys: true},
+			expected: device{DeviceId: "edge-1", Port: 8443}},
+		{name: "other prefix", prefix: "CLOUD", options: LoadOptions{NormalizeKeys: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var object device
+			if err := LoadEnvironmentWithPrefixAndOptions(test.prefix, &object, "config", test.options); err != nil {
+				t.Fatal(err)
+			}
+			if object != test.expected {
+				t.Errorf("Loaded %+v, expected %+v", object, test.expected)
+			}
+		})
+	}
+}
+
+func TestLoadLayered(t *testing.T) {
+	tests := []struct {
+		name     string
+		sources  []Source
+		expected device
+		wantErr  bool
+	}{
+		{name: "later sources override", sources: []Source{
+			PropertiesSource(map[string]string{"DeviceId": "edge-1", "port": "80"}),
+			PropertiesSource(map[string]string{"port": "8443"}),
+		}, expected: device{DeviceId: "edge-1", Port: 8443}},
+		{name: "optional file", sources: []Source{
+			FileSource(filepath.Join(t.TempDir(), "missing.properties"), true),
+			PropertiesSource(map[string]string{"port": "8443"}),
+		}, expected: device{Port: 8443}},
+		{name: "required file", sources: []Source{
+			FileSource(filepath.Join(t.TempDir(), "missing.properties"), false),
+		}, wantErr: true},
+		{name: "zero source", sources: []Source{PropertiesSource(nil), {}}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var object device
+			err := LoadLayered(&object, "config", test.sources...)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("LoadLayered returned %v, expected an error: %v", err, test.wantErr)
+			}
+			if object != test.expected {
+				t.Errorf("Loaded %+v, expected %+v", object, test.expected)
+			}
+		})
+	}
+}
+
+func TestUnusedKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties map[string]string
+		options    LoadOptions
+		expected   []string
+	}{
+		{
+			name:       "exact names",
+			properties: map[string]string{"DeviceId": "edge-1", "port": "80", "device-id": "edge-2", "prot": "80"},
+			expected:   []string{"device-id", "prot"},
+		},
+		{
+			name:       "normalized names",
+			properties: map[string]string{"device-id": "edge-1", "PORT": "80", "prot": "80"},
+			options:    LoadOptions{NormalizeKeys: true},
+			expected:   []string{"prot"},
+		},
+		{
+			name:       "names normalized to the same name",
+			properties: map[string]string{"device-id": "edge-1", "DEVICE_ID": "edge-2"},
+			options:    LoadOptions{NormalizeKeys: true},
+			expected:   []string{"device-id"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			unused := UnusedKeysWithOptions(test.properties, &device{}, "config", test.options)
+			if !reflect.DeepEqual(unused, test.expected) {
+				t.Errorf("Unused keys %v, expected %v", unused, test.expected)
+			}
+		})
+	}
+}
+
+type written struct {
+	Token   string            `config:"token"`
+	Port    int               `config:"port"`
+	Labels  map[string]string `match:"^label\\." strip:"label."`
+	Servers []string          `indexed:"server"`
+}
+
+func TestWritePropertiesFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		object written
+	}{
+		{name: "plain values", object: written{Token: "abc", Port: 80}},
+		{name: "value starting with =", object: written{Token: "=abc="}},
+		{name: "value starting with :", object: written{Token: ":abc"}},
+		{name: "value of a separator", object: written{Token: "="}},
+		{name: "matched and indexed values", object: written{
+			Labels:  map[string]string{"label.zone": ":east", "label.rack": "=1"},
+			Servers: []string{"=first", "second"},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "written.properties")
+			if err := WritePropertiesFile(fileName, &test.object, "config"); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode := info.Mode().Perm(); mode != 0600 {
+				t.Errorf("The file mode is %o, expected 600", mode)
+			}
+
+			var loaded written
+			if err := LoadPropertiesFile(fileName, false, &loaded, "config"); err != nil {
+				t.Fatal(err)
+			}
+			// Match fields are always loaded with a map
+			if test.object.Labels == nil {
+				test.object.Labels = map[string]string{}
+			}
+			if !reflect.DeepEqual(loaded, test.object) {
+				t.Errorf("Loaded %+v, expected %+v", loaded, test.object)
+			}
+		})
+	}
+}
+
+func TestReadSections(t *testing.T) {
+	content := "name edge\n[tls]\ncert foo\nkey = bar\n\n[ mqtt ]\nhost: broker\n[tls.client]\ncert baz\n"
+	tests := []struct {
+		name     string
+		options  ReadOptions
+		expected map[string]string
+		err      bool
+	}{
+		{
+			name:    "sections",
+			options: ReadOptions{Sections: true},
+			expected: map[string]string{"name": "edge", "tls.cert": "foo", "tls.key": "bar",
+				"mqtt.host": "broker", "tls.client.cert": "baz"},
+		},
+		{
+			name:    "default section",
+			options: ReadOptions{Sections: true, DefaultSection: "main"},
+			expected: map[string]string{"main.name": "edge", "tls.cert": "foo", "tls.key": "bar",
+				"mqtt.host": "broker", "tls.client.cert": "baz"},
+		},
+		{
+			name:    "sections disabled, the cert keys clash",
+			options: ReadOptions{},
+			err:     true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			properties, err := ReadPropertiesWithOptions(strings.NewReader(content), test.options)
+			if test.err {
+				if err == nil {
+					t.Errorf("Expected an error, read %v", properties)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(properties, test.expected) {
+				t.Errorf("Read %v, expected %v", properties, test.expected)
+			}
+		})
+	}
+}
+
+type encoded struct {
+	Default []byte `config:"default"`
+	Base64  []byte `config:"base64" encoding:"base64"`
+	Hex     []byte `config:"hex" encoding:"HEX"`
+	Other   []byte `config:"other" encoding:"base32"`
+}
+
+func TestLoadBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		value    string
+		expected []byte
+		err      string
+	}{
+		{name: "default encoding", key: "default", value: "AAEC/w==", expected: []byte{0, 1, 2, 255}},
+		{name: "base64", key: "base64", value: " aGVsbG8= ", expected: []byte("hello")},
+		{name: "hex", key: "hex", value: "00ff10", expected: []byte{0, 255, 16}},
+		{name: "empty", key: "hex", value: "", expected: []byte{}},
+		{name: "malformed base64", key: "base64", value: "aGVsbG8", err: "base64"},
+		{name: "malformed hex", key: "hex", value: "0g", err: "hex"},
+		{name: "unsupported encoding", key: "other", value: "ME", err: "unsupported encoding 'base32'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var object encoded
+			err := LoadProperties(map[string]string{test.key: test.value}, &object, "config")
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) || !strings.Contains(err.Error(), test.key) {
+					t.Fatalf("Expected an error about %s, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded := map[string][]byte{"default": object.Default, "base64": object.Base64, "hex": object.Hex}[test.key]
+			if !bytes.Equal(loaded, test.expected) {
+				t.Errorf("Loaded %v, expected %v", loaded, test.expected)
+			}
+		})
+	}
+}
+
+type trimmed struct {
+	Enabled bool    `config:"TRIM_ENABLED"`
+	Count   int     `config:"TRIM_COUNT"`
+	Ratio   float64 `config:"TRIM_RATIO"`
+	Name    string  `config:"TRIM_NAME"`
+}
+
+func TestLoadTrimmedEnvironment(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		value    string
+		expected trimmed
+		err      bool
+	}{
+		{name: "leading space bool", key: "TRIM_ENABLED", value: " true", expected: trimmed{Enabled: true}},
+		{name: "trailing newline bool", key: "TRIM_ENABLED", value: "TRUE\n", expected: trimmed{Enabled: true}},
+		{name: "mixed case bool", key: "TRIM_ENABLED", value: "\tYes ", expected: trimmed{Enabled: true}},
+		{name: "false bool", key: "TRIM_ENABLED", value: " Off ", expected: trimmed{}},
+		{name: "int", key: "TRIM_COUNT", value: " 42\t", expected: trimmed{Count: 42}},
+		{name: "float", key: "TRIM_RATIO", value: "  0.5 ", expected: trimmed{Ratio: 0.5}},
+		{name: "string kept as is", key: "TRIM_NAME", value: " edge ", expected: trimmed{Name: " edge "}},
+		{name: "invalid bool", key: "TRIM_ENABLED", value: " maybe ", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(test.key, test.value)
+			var object trimmed
+			err := LoadEnvironment(&object, "config")
+			if (err != nil) != test.err {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if !test.err && object != test.expected {
+				t.Errorf("Loaded %+v, expected %+v", object, test.expected)
+			}
+		})
+	}
+}
+
+type plugins struct {
+	Name     string            `config:"name"`
+	Plugins  map[string]string `match:"^plugin\\..+" strip:"plugin."`
+	Limits   map[string]int    `match:"^limit\\."`
+	Invalid  map[string]string `match:"^(unclosed"`
+	NotAMap  []string          `match:"^list\\."`
+	Untagged map[string]string
+}
+
+func TestLoadMatches(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties map[string]string
+		plugins    map[string]string
+		limits     map[string]int
+		unused     []string
+		err        string
+	}{
+		{
+			name: "subset of the keys",
+			properties: map[string]string{"name": "edge", "plugin.mqtt": "enabled", "plugin.http": "disabled",
+				"plugins": "none", "xplugin.ssh": "enabled", "limit.cpu": "2"},
+			plugins: map[string]string{"mqtt": "enabled", "http": "disabled"},
+			limits:  map[string]int{"limit.cpu": 2},
+			unused:  []string{"plugins", "xplugin.ssh"},
+		},
+		{
+			name:       "no matching keys",
+			properties: map[string]string{"name": "edge"},
+			plugins:    map[string]string{},
+			limits:     map[string]int{},
+			unused:     []string{},
+		},
+		{
+			name:       "value not converted",
+			properties: map[string]string{"limit.cpu": "two", "limit.memory": "512"},
+			limits:     map[string]int{"limit.memory": 512},
+			err:        "limit.cpu",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var object plugins
+			err := LoadProperties(test.properties, &object, "config")
+			var errs []string
+			if loadErr, ok := err.(*LoadError); ok {
+				for _, e := range loadErr.Errors {
+					errs = append(errs, e.Error())
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			// The invalid match tags are always reported
+			if joined := strings.Join(errs, "\n"); !strings.Contains(joined, "field Invalid") || !strings.Contains(joined, "field NotAMap") {
+				t.Errorf("The invalid match tags weren't reported: %q", errs)
+			}
+			if test.err != "" && !strings.Contains(strings.Join(errs, "\n"), test.err) {
+				t.Errorf("Expected an error about %s, got %q", test.err, errs)
+			}
+			if test.plugins != nil && !reflect.DeepEqual(object.Plugins, test.plugins) {
+				t.Errorf("Loaded the plugins %v, expected %v", object.Plugins, test.plugins)
+			}
+			if !reflect.DeepEqual(object.Limits, test.limits) {
+				t.Errorf("Loaded the limits %v, expected %v", object.Limits, test.limits)
+			}
+			if test.unused != nil {
+				if unused := UnusedKeys(test.properties, &object, "config"); !reflect.DeepEqual(unused, test.unused) {
+					t.Errorf("Unused keys %v, expected %v", unused, test.unused)
+				}
+			}
+		})
+	}
+}
+
+type peers struct {
+	Peers []string `indexed:"TEST_PEER"`
+	Ports []int    `indexed:"TEST_PORT"`
+}
+
+func TestLoadIndexedEnvironment(t *testing.T) {
+	tests := []struct {
+		name        string
+		environment map[string]string
+		peers       []string
+		ports       []int
+		err         bool
+	}{
+		{
+			name:        "contiguous",
+			environment: map[string]string{"TEST_PEER_0": "a", "TEST_PEER_1": "b", "TEST_PEER_2": "c", "TEST_PORT_0": "80"},
+			peers:       []string{"a", "b", "c"},
+			ports:       []int{80},
+		},
+		{
+			name:        "gap",
+			environment: map[string]string{"TEST_PEER_0": "a", "TEST_PEER_1": "b", "TEST_PEER_3": "d"},
+			peers:       []string{"a", "b"},
+		},
+		{
+			name:        "no first index",
+			environment: map[string]string{"TEST_PEER_1": "b", "TEST_PEER_2": "c"},
+		},
+		{
+			name:        "invalid element",
+			environment: map[string]string{"TEST_PORT_0": "80", "TEST_PORT_1": "http", "TEST_PORT_2": "443"},
+			ports:       []int{80, 443},
+			err:         true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.environment {
+				t.Setenv(key, value)
+			}
+			var object peers
+			err := LoadEnvironment(&object, "config")
+			if (err != nil) != test.err {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(object.Peers, test.peers) || !reflect.DeepEqual(object.Ports, test.ports) {
+				t.Errorf("Loaded %v and %v, expected %v and %v", object.Peers, object.Ports, test.peers, test.ports)
+			}
+		})
+	}
+}
+
+func TestReadMultiWordValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		key      string
+		expected string
+	}{
+		{"single spaces", "Description My Edge Node", "Description", "My Edge Node"},
+		{"multiple spaces", "Description   My  Edge   Node  ", "Description", "My  Edge   Node"},
+		{"tabs", "Description\tMy\tEdge\t\tNode\t", "Description", "My\tEdge\t\tNode"},
+		{"separator", "Description =  My Edge Node ", "Description", "My Edge Node"},
+		{"colon separator", "  Description:My Edge Node", "Description", "My Edge Node"},
+		{"no value", "Description", "Description", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			properties, err := ReadProperties(strings.NewReader(test.line + "\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value, ok := properties[test.key]; !ok || value != test.expected {
+				t.Errorf("Read %q, expected %q", value, test.expected)
+			}
+		})
+	}
+}
+
+type window struct {
+	Start  time.Time  `config:"start"`
+	Date   time.Time  `config:"date" timeformat:"2006-01-02"`
+	Expiry *time.Time `config:"expiry" timeformat:"2006-01-02 15:04"`
+}
+
+func TestLoadTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		value    string
+		expected time.Time
+		err      bool
+	}{
+		{"RFC3339", "start", "2024-01-15T10:30:00Z", time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC), false},
+		{"RFC3339 with a zone", "start", "2024-01-15T10:30:00+02:00",
+			time.Date(2024, 1, 15, 8, 30, 0, 0, time.UTC), false},
+		{"custom layout", "date", " 2024-01-15 ", time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), false},
+		{"pointer", "expiry", "2024-01-15 23:59", time.Date(2024, 1, 15, 23, 59, 0, 0, time.UTC), false},
+		{"empty", "start", "", time.Time{}, false},
+		{"malformed", "start", "2024-01-15", time.Time{}, true},
+		{"wrong layout", "date", "15/01/2024", time.Time{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var object window
+			err := LoadProperties(map[string]string{test.key: test.value}, &object, "config")
+			if test.err {
+				if err == nil || !strings.Contains(err.Error(), test.key) {
+					t.Errorf("Expected an error about %s, got %v", test.key, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded := map[string]time.Time{"start": object.Start, "date": object.Date}[test.key]
+			if test.key == "expiry" && object.Expiry != nil {
+				loaded = *object.Expiry
+			}
+			if !loaded.Equal(test.expected) {
+				t.Errorf("Loaded %s, expected %s", loaded, test.expected)
+			}
+		})
+	}
+}
+
+type certificates struct {
+	CertFile string
+	KeyFile  string `config:"key"`
+}
+
+type tlsConfig struct {
+	Enabled bool `config:"enabled"`
+	Client  certificates
+	Server  certificates `config:"server"`
+}
+
+type common struct {
+	Name string `config:"name"`
+}
+
+type hierarchical struct {
+	common
+	TLS  tlsConfig
+	Port int `config:"port"`
+}
+
+func TestLoadNestedStructs(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties map[string]string
+		expected   hierarchical
+	}{
+		{
+			name: "two levels",
+			properties: map[string]string{"TLS.enabled": "true", "TLS.Client.CertFile": "client.pem",
+				"TLS.Client.key": "client.key", "TLS.server.CertFile": "server.pem", "port": "8443"},
+			expected: hierarchical{Port: 8443, TLS: tlsConfig{Enabled: true,
+				Client: certificates{CertFile: "client.pem", KeyFile: "client.key"},
+				Server: certificates{CertFile: "server.pem"}}},
+		},
+		{
+			name:       "embedded struct without a prefix",
+			properties: map[string]string{"name": "edge", "common.name": "prefixed"},
+			expected:   hierarchical{common: common{Name: "edge"}},
+		},
+		{
+			name:       "keys without the prefix ignored",
+			properties: map[string]string{"CertFile": "client.pem", "Client.CertFile": "client.pem", "enabled": "true"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var object hierarchical
+			if err := LoadProperties(test.properties, &object, "config"); err != nil {
+				t.Fatal(err)
+			}
+			if object != test.expected {
+				t.Errorf("Loaded %+v, expected %+v", object, test.expected)
+			}
+		})
+	}
+}